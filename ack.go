@@ -0,0 +1,128 @@
+package siosver
+
+import (
+	"sync"
+	"time"
+)
+
+// Response is acknowledgement of a socket to broadcast
+type Response struct {
+	Socket *Socket
+	Args   []interface{}
+}
+
+// AckTimeoutError reports sockets which did not acknowledge broadcast before
+// timeout or disconnected
+type AckTimeoutError struct {
+	Sockets []*Socket
+}
+
+func (err *AckTimeoutError) Error() string {
+	return "operation has timed out"
+}
+
+// emitWithAck emits event with ack id and calls f with arguments of client
+// acknowledgement. It returns the ack id to cancel waiting by cancelAck.
+func (socket *Socket) emitWithAck(args []interface{}, flags emitFlags, f func([]interface{})) (int, error) {
+	socket.acksMtx.Lock()
+	id := socket.nextAckId
+	socket.nextAckId++
+	socket.acks[id] = f
+	socket.acksMtx.Unlock()
+
+	if err := socket.sendWithFlags(newPacket(__SIO_PACKET_EVENT, args...).withAck(id), flags); err != nil {
+		socket.cancelAck(id)
+		return id, err
+	}
+	return id, nil
+}
+
+// cancelAck stops waiting acknowledgement of id
+func (socket *Socket) cancelAck(id int) {
+	socket.acksMtx.Lock()
+	defer socket.acksMtx.Unlock()
+	delete(socket.acks, id)
+}
+
+// onAck handles acknowledgement sent by client
+func (socket *Socket) onAck(p *packet) {
+	socket.acksMtx.Lock()
+	f, isFound := socket.acks[p.ackId]
+	delete(socket.acks, p.ackId)
+	socket.acksMtx.Unlock()
+
+	if !isFound {
+		return
+	}
+
+	args, _ := p.data.([]interface{})
+	f(args)
+}
+
+// EmitWithAck emits event to targeted sockets and calls f with their
+// acknowledgements once all sockets answer, or timeout set by Timeout
+// elapses. err is *AckTimeoutError if some sockets did not answer or
+// disconnected. f is called in another goroutine.
+func (b *BroadcastOperator) EmitWithAck(event string, args []interface{}, f func(err error, responses []Response)) {
+	packetArgs := append([]interface{}{event}, args...)
+
+	expired := make(chan struct{})
+	var timer *time.Timer
+	if b.ackTimeout > 0 {
+		timer = time.AfterFunc(b.ackTimeout, func() { close(expired) })
+	}
+
+	mtx := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	responses := []Response{}
+	missing := []*Socket{}
+
+	for _, socket := range b.targets() {
+		acked := make(chan []interface{}, 1)
+		id, err := socket.emitWithAck(packetArgs, b.flags, func(ackArgs []interface{}) {
+			acked <- ackArgs
+		})
+		if err != nil {
+			mtx.Lock()
+			missing = append(missing, socket)
+			mtx.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(socket *Socket, id int) {
+			defer wg.Done()
+
+			select {
+			case ackArgs := <-acked:
+				mtx.Lock()
+				responses = append(responses, Response{Socket: socket, Args: ackArgs})
+				mtx.Unlock()
+				return
+
+			case <-socket.ctx.Done():
+			case <-expired:
+			}
+
+			socket.cancelAck(id)
+			mtx.Lock()
+			missing = append(missing, socket)
+			mtx.Unlock()
+		}(socket, id)
+	}
+
+	go func() {
+		wg.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+
+		var err error
+		if len(missing) > 0 {
+			err = &AckTimeoutError{Sockets: missing}
+		}
+
+		defer b.server.recoverPanic(nil, event)
+		f(err, responses)
+	}()
+}
@@ -0,0 +1,139 @@
+package siosver
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ackResult struct {
+	err       error
+	responses []Response
+}
+
+// emitWithAck runs EmitWithAck of b and returns channel of its result
+func emitWithAck(b *BroadcastOperator, event string, args ...interface{}) chan ackResult {
+	result := make(chan ackResult, 1)
+	b.EmitWithAck(event, args, func(err error, responses []Response) {
+		result <- ackResult{err, responses}
+	})
+	return result
+}
+
+func waitAckResult(t *testing.T, result chan ackResult) ackResult {
+	t.Helper()
+
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback of EmitWithAck is not called")
+		return ackResult{}
+	}
+}
+
+// newTestRoom connects n clients joining room
+func newTestRoom(t *testing.T, n int) (*Server, []*testClient) {
+	t.Helper()
+
+	server := NewServer(ServerOptions{PingInterval: 25000, PingTimeout: 20000})
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	clients := []*testClient{}
+	for i := 0; i < n; i++ {
+		c := newTestClient(t, server, ts)
+		c.Socket.SocketJoin("room")
+		clients = append(clients, c)
+	}
+	return server, clients
+}
+
+// checkMissing checks that err is *AckTimeoutError of sockets
+func checkMissing(t *testing.T, err error, sockets ...*Socket) {
+	t.Helper()
+
+	timeoutErr, isOk := err.(*AckTimeoutError)
+	if !isOk {
+		t.Fatalf("error is %v, want AckTimeoutError", err)
+	}
+	if !reflect.DeepEqual(timeoutErr.Sockets, sockets) {
+		t.Errorf("missing sockets are %v, want %v", timeoutErr.Sockets, sockets)
+	}
+}
+
+func TestEmitWithAck(t *testing.T) {
+	server, clients := newTestRoom(t, 2)
+
+	result := emitWithAck(server.To("room").Timeout(time.Second), "ask", 1)
+	for _, c := range clients {
+		c.expect(`20["ask",1]`)
+		c.send(`30["ok"]`)
+	}
+
+	r := waitAckResult(t, result)
+	if r.err != nil {
+		t.Fatalf("error is %v", r.err)
+	}
+	if len(r.responses) != 2 {
+		t.Fatalf("responses are %+v, want 2", r.responses)
+	}
+	for _, response := range r.responses {
+		if !reflect.DeepEqual(response.Args, []interface{}{"ok"}) {
+			t.Errorf("response of %s is %v", response.Socket.ID(), response.Args)
+		}
+	}
+}
+
+func TestEmitWithAckTimeout(t *testing.T) {
+	server, clients := newTestRoom(t, 2)
+	answering, silent := clients[0], clients[1]
+
+	result := emitWithAck(server.To("room").Timeout(100*time.Millisecond), "ask")
+	answering.expect(`20["ask"]`)
+	answering.send(`30["ok"]`)
+	silent.expect(`20["ask"]`)
+
+	r := waitAckResult(t, result)
+	checkMissing(t, r.err, silent.Socket)
+	if len(r.responses) != 1 || r.responses[0].Socket != answering.Socket {
+		t.Errorf("responses are %+v, want response of answering socket", r.responses)
+	}
+
+	// late acknowledgement is ignored
+	silent.send(`30["late"]`)
+}
+
+func TestEmitWithAckDisconnect(t *testing.T) {
+	server, clients := newTestRoom(t, 2)
+	answering, leaving := clients[0], clients[1]
+
+	// zero timeout waits until sockets answer or disconnect
+	result := emitWithAck(server.To("room").Timeout(0), "ask")
+	answering.expect(`20["ask"]`)
+	leaving.expect(`20["ask"]`)
+	leaving.close()
+
+	select {
+	case r := <-result:
+		t.Fatalf("callback is called before all sockets answer, error %v", r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	answering.send(`30["ok"]`)
+
+	r := waitAckResult(t, result)
+	checkMissing(t, r.err, leaving.Socket)
+	if len(r.responses) != 1 || r.responses[0].Socket != answering.Socket {
+		t.Errorf("responses are %+v, want response of answering socket", r.responses)
+	}
+}
+
+func TestEmitWithAckNoTarget(t *testing.T) {
+	server := NewServer(ServerOptions{})
+
+	r := waitAckResult(t, emitWithAck(server.To("empty"), "ask"))
+	if r.err != nil || len(r.responses) != 0 {
+		t.Errorf("result is %v %+v, want no error and no response", r.err, r.responses)
+	}
+}
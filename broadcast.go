@@ -0,0 +1,179 @@
+package siosver
+
+import "time"
+
+// emitFlags modify how an emitted packet is sent
+type emitFlags struct {
+	noCompress  bool
+	volatile    bool
+	waitWritten bool
+	written     chan error // see engineio.SendOptions.Written
+	timeout     time.Duration
+}
+
+// SocketEmitter emits to a socket with modified flags
+type SocketEmitter struct {
+	socket *Socket
+	flags  emitFlags
+}
+
+// Compress sets whether emitted data will be compressed
+func (e *SocketEmitter) Compress(compress bool) *SocketEmitter {
+	e.flags.noCompress = !compress
+	return e
+}
+
+// Volatile makes emitted data dropped if client is not ready to receive it
+func (e *SocketEmitter) Volatile() *SocketEmitter {
+	e.flags.volatile = true
+	return e
+}
+
+func (e *SocketEmitter) Emit(arg ...interface{}) {
+	e.socket.sendWithFlags(newPacket(__SIO_PACKET_EVENT, arg...), e.flags)
+}
+
+// TryEmit emits event and returns error if it can not be queued
+func (e *SocketEmitter) TryEmit(arg ...interface{}) error {
+	return e.socket.sendWithFlags(newPacket(__SIO_PACKET_EVENT, arg...), e.flags)
+}
+
+// EmitAndWait emits event and blocks until it is written to transport
+func (e *SocketEmitter) EmitAndWait(timeout time.Duration, arg ...interface{}) error {
+	flags := e.flags
+	flags.waitWritten = true
+	flags.timeout = timeout
+	return e.socket.sendWithFlags(newPacket(__SIO_PACKET_EVENT, arg...), flags)
+}
+
+// BroadcastOperator emits to sockets of rooms and listed sockets with
+// modified flags
+type BroadcastOperator struct {
+	server    *Server
+	rooms     []*Room
+	roomNames []string // looked up on emit
+	users     []string // added by ToUser
+	sockets   Sockets
+	flags     emitFlags
+
+	ackTimeout time.Duration // of EmitWithAck
+}
+
+// To adds rooms to emit to. Socket id can be used as room name.
+func (b *BroadcastOperator) To(roomNames ...string) *BroadcastOperator {
+	b.roomNames = append(b.roomNames, roomNames...)
+	return b
+}
+
+// ToUser adds sockets of users to emit to
+func (b *BroadcastOperator) ToUser(ids ...string) *BroadcastOperator {
+	for _, id := range ids {
+		b.roomNames = append(b.roomNames, userRoomName(id))
+	}
+	b.users = append(b.users, ids...)
+	return b
+}
+
+// Timeout sets how long EmitWithAck waits acknowledgements, zero waits until
+// all sockets answer or disconnect
+func (b *BroadcastOperator) Timeout(timeout time.Duration) *BroadcastOperator {
+	b.ackTimeout = timeout
+	return b
+}
+
+// Compress sets whether emitted data will be compressed
+func (b *BroadcastOperator) Compress(compress bool) *BroadcastOperator {
+	b.flags.noCompress = !compress
+	return b
+}
+
+// Volatile makes emitted data dropped by sockets not ready to receive it
+func (b *BroadcastOperator) Volatile() *BroadcastOperator {
+	b.flags.volatile = true
+	return b
+}
+
+func (b *BroadcastOperator) Emit(arg ...interface{}) {
+	if b.server == nil || b.flags.volatile {
+		b.send(b.targets(), arg)
+		return
+	}
+
+	// users without connected socket get it when they connect. Sockets
+	// bound to users or joining rooms having history after targets are
+	// taken get it from offline store or history.
+	storesOffline := len(b.users) > 0 && b.server.offlineStore != nil
+	if storesOffline {
+		b.server.offlineMtx.Lock()
+		b.server.storeOffline(b.users, arg)
+	}
+	b.server.historyMtx.Lock()
+	b.record(arg)
+	targets := b.targets()
+	b.server.historyMtx.Unlock()
+	if storesOffline {
+		b.server.offlineMtx.Unlock()
+	}
+
+	b.send(targets, arg)
+}
+
+func (b *BroadcastOperator) send(targets Sockets, args []interface{}) {
+	packet := newPacket(__SIO_PACKET_EVENT, args...)
+	for _, socket := range targets {
+		socket.sendWithFlags(packet, b.flags)
+	}
+}
+
+// record adds event to history of rooms, server.historyMtx must be locked
+func (b *BroadcastOperator) record(args []interface{}) {
+	if len(b.server.histories) == 0 {
+		return
+	}
+
+	names := append([]string{}, b.roomNames...)
+	for _, room := range b.rooms {
+		names = append(names, room.Name)
+	}
+
+	// args of caller may be modified after emit
+	var recorded []interface{}
+	isRecorded := map[string]bool{}
+	for _, name := range names {
+		if history, isFound := b.server.histories[name]; isFound && !isRecorded[name] {
+			if recorded == nil {
+				recorded = copyData(args).([]interface{})
+			}
+			history.record(recorded)
+			isRecorded[name] = true
+		}
+	}
+}
+
+// targets returns sockets of rooms and listed sockets, each once
+func (b *BroadcastOperator) targets() Sockets {
+	targets := Sockets{}
+	for _, room := range b.rooms {
+		room.server.roomsMtx.Lock()
+		for id, socket := range room.sockets {
+			targets[id] = socket
+		}
+		room.server.roomsMtx.Unlock()
+	}
+	// without server there is no room, see Sockets.operator
+	if len(b.roomNames) > 0 && b.server != nil {
+		b.server.roomsMtx.Lock()
+		for _, name := range b.roomNames {
+			if room, isFound := b.server.rooms[name]; isFound {
+				for id, socket := range room.sockets {
+					targets[id] = socket
+				}
+			}
+		}
+		b.server.roomsMtx.Unlock()
+	}
+	for id, socket := range b.sockets {
+		targets[id] = socket
+	}
+	return targets
+}
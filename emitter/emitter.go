@@ -1,105 +1,145 @@
 package emitter
 
 import (
+	"log"
 	"reflect"
+	"sort"
 	"sync"
 )
 
+// DEFAULT_MAX_LISTENERS is default number of listeners of an event above
+// which a possible leak is warned
+const DEFAULT_MAX_LISTENERS int = 10
+
 type listener func(...interface{})
 
-type listeners struct {
-	listenerType
+// registration is a listener added by On, Once or Prepend. Its pointer is
+// its identity, so the same function may be added many times.
+type registration struct {
 	listener
-	next *listeners
+	isOnce bool
 }
 
-type listenerType byte
-
-const (
-	listenerTypeOn listenerType = iota
-	listenerTypeOnce
-)
-
+// EventEmitter calls listeners of emitted events. Listeners are called with
+// the mutex unlocked from a snapshot taken when emitting, so they may add or
+// remove listeners and emit events. Listeners added meanwhile are called from
+// the next emit.
 type EventEmitter struct {
 	mutex        *sync.Mutex
-	listenersMap map[string]*listeners
+	listenersMap map[string][]*registration // never modified in place
+	maxListeners int
+	warned       map[string]bool
 }
 
 func New() *EventEmitter {
 	return &EventEmitter{
 		mutex:        &sync.Mutex{},
-		listenersMap: make(map[string]*listeners),
+		listenersMap: make(map[string][]*registration),
+		maxListeners: DEFAULT_MAX_LISTENERS,
+		warned:       make(map[string]bool),
 	}
 }
 
+// Emit calls listeners of event in order. Listeners added by Once are
+// removed before they are called, so they are called once even if event is
+// emitted concurrently.
 func (emitter *EventEmitter) Emit(event string, arg ...interface{}) {
 	emitter.mutex.Lock()
-	defer emitter.mutex.Unlock()
+	eventListeners := emitter.listenersMap[event]
 
-	eventListeners, isFound := emitter.listenersMap[event]
-	if isFound && eventListeners != nil {
-		ptr := eventListeners
-		for ptr != nil {
-			ptr.listener(arg...)
-			ptr = ptr.next
+	for _, r := range eventListeners {
+		if r.isOnce {
+			emitter.remove(event, func(other *registration) bool { return other.isOnce })
+			break
 		}
 	}
+	emitter.mutex.Unlock()
+
+	for _, r := range eventListeners {
+		r.listener(arg...)
+	}
 }
 
-func (emitter *EventEmitter) On(event string, f listener) {
-	emitter.mutex.Lock()
-	defer emitter.mutex.Unlock()
+// On adds listener of event and returns func removing it
+func (emitter *EventEmitter) On(event string, f listener) func() {
+	return emitter.add(event, &registration{listener: f}, false)
+}
+
+// Once adds listener of event which is removed when event is emitted. It
+// returns func removing it before.
+func (emitter *EventEmitter) Once(event string, f listener) func() {
+	return emitter.add(event, &registration{listener: f, isOnce: true}, false)
+}
 
-	newListener := &listeners{listenerTypeOn, f, nil}
+// Prepend adds listener of event which is called before the listeners
+// already added. It returns func removing it.
+func (emitter *EventEmitter) Prepend(event string, f listener) func() {
+	return emitter.add(event, &registration{listener: f}, true)
+}
 
-	eventListeners, isFound := emitter.listenersMap[event]
-	if !isFound || eventListeners == nil {
-		emitter.listenersMap[event] = newListener
+func (emitter *EventEmitter) add(event string, r *registration, isPrepended bool) func() {
+	emitter.mutex.Lock()
+	defer emitter.mutex.Unlock()
 
-		return
+	eventListeners := emitter.listenersMap[event]
+	newListeners := make([]*registration, 0, len(eventListeners)+1)
+	if isPrepended {
+		newListeners = append(newListeners, r)
+		newListeners = append(newListeners, eventListeners...)
+	} else {
+		newListeners = append(newListeners, eventListeners...)
+		newListeners = append(newListeners, r)
 	}
+	emitter.listenersMap[event] = newListeners
 
-	ptr := eventListeners
+	if emitter.maxListeners > 0 && len(newListeners) > emitter.maxListeners && !emitter.warned[event] {
+		emitter.warned[event] = true
+		log.Printf("emitter: possible leak, %d listeners of event %q are added, max is %d",
+			len(newListeners), event, emitter.maxListeners)
+	}
 
-	for ptr != nil {
-		if reflect.ValueOf(ptr.listener).Pointer() == reflect.ValueOf(f).Pointer() {
-			ptr.listenerType = listenerTypeOn
-			return
-		}
-		if ptr.next == nil {
-			ptr.next = newListener
-			return
-		}
-		ptr = ptr.next
+	return func() {
+		emitter.mutex.Lock()
+		defer emitter.mutex.Unlock()
+		emitter.remove(event, func(other *registration) bool { return other == r })
 	}
 }
 
-func (emitter *EventEmitter) Once(event string, f listener) {
-	emitter.mutex.Lock()
-	defer emitter.mutex.Unlock()
-
-	newListener := &listeners{listenerTypeOnce, f, nil}
-
-	eventListeners, isFound := emitter.listenersMap[event]
-	if !isFound || eventListeners == nil {
-		emitter.listenersMap[event] = newListener
+// remove removes listeners of event matching isRemoved,
+// emitter.mutex must be locked
+func (emitter *EventEmitter) remove(event string, isRemoved func(*registration) bool) {
+	eventListeners := emitter.listenersMap[event]
+	newListeners := make([]*registration, 0, len(eventListeners))
+	for _, r := range eventListeners {
+		if !isRemoved(r) {
+			newListeners = append(newListeners, r)
+		}
+	}
 
+	if len(newListeners) == 0 {
+		delete(emitter.listenersMap, event)
 		return
 	}
+	emitter.listenersMap[event] = newListeners
+}
 
-	ptr := eventListeners
+// RemoveListener removes first listener of event whose function is f.
+//
+// Deprecated: functions are compared by code pointer, so closures of the same
+// literal can not be told apart. Use func returned by On, Once or Prepend.
+func (emitter *EventEmitter) RemoveListener(event string, f listener) {
+	emitter.mutex.Lock()
+	defer emitter.mutex.Unlock()
 
-	for ptr != nil {
-		if reflect.ValueOf(ptr.listener).Pointer() == reflect.ValueOf(f).Pointer() {
-			ptr.listenerType = listenerTypeOn
-			return
-		}
-		if ptr.next == nil {
-			ptr.next = newListener
-			return
+	ptr := reflect.ValueOf(f).Pointer()
+	isFound := false
+	emitter.remove(event, func(r *registration) bool {
+		if isFound || reflect.ValueOf(r.listener).Pointer() != ptr {
+			return false
 		}
-		ptr = ptr.next
-	}
+		isFound = true
+		return true
+	})
 }
 
 func (emitter *EventEmitter) RemoveAllListeners(event string) {
@@ -109,30 +149,32 @@ func (emitter *EventEmitter) RemoveAllListeners(event string) {
 	delete(emitter.listenersMap, event)
 }
 
-func (emitter *EventEmitter) RemoveListener(event string, f listener) {
+// ListenerCount returns number of listeners of event
+func (emitter *EventEmitter) ListenerCount(event string) int {
 	emitter.mutex.Lock()
 	defer emitter.mutex.Unlock()
 
-	eventListeners, isFound := emitter.listenersMap[event]
-	if isFound {
-		ptr := eventListeners
-		var prev *listeners = nil
-
-		for ptr != nil {
-			if reflect.ValueOf(ptr.listener).Pointer() == reflect.ValueOf(f).Pointer() {
-				if prev == nil {
-					if ptr.next == nil {
-						delete(emitter.listenersMap, event)
-						return
-					}
-					emitter.listenersMap[event] = ptr.next
-				} else {
-					prev.next = ptr.next
-				}
-				break
-			}
-			prev = ptr
-			ptr = ptr.next
-		}
+	return len(emitter.listenersMap[event])
+}
+
+// EventNames returns sorted names of events having listeners
+func (emitter *EventEmitter) EventNames() []string {
+	emitter.mutex.Lock()
+	defer emitter.mutex.Unlock()
+
+	names := make([]string, 0, len(emitter.listenersMap))
+	for event := range emitter.listenersMap {
+		names = append(names, event)
 	}
+	sort.Strings(names)
+	return names
+}
+
+// SetMaxListeners sets number of listeners of an event above which a possible
+// leak is logged once per event, 0 disables the warning
+func (emitter *EventEmitter) SetMaxListeners(n int) {
+	emitter.mutex.Lock()
+	defer emitter.mutex.Unlock()
+
+	emitter.maxListeners = n
 }
@@ -0,0 +1,184 @@
+package emitter
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOnce(t *testing.T) {
+	emitter := New()
+
+	calls := 0
+	emitter.Once("event", func(args ...interface{}) { calls++ })
+	emitter.Emit("event")
+	emitter.Emit("event")
+
+	if calls != 1 {
+		t.Errorf("once listener is called %d times", calls)
+	}
+	if n := emitter.ListenerCount("event"); n != 0 {
+		t.Errorf("%d listeners remain after once listener is called", n)
+	}
+}
+
+func TestOnceConcurrent(t *testing.T) {
+	emitter := New()
+
+	mtx := &sync.Mutex{}
+	calls := 0
+	emitter.Once("event", func(args ...interface{}) {
+		mtx.Lock()
+		calls++
+		mtx.Unlock()
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			emitter.Emit("event")
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("once listener is called %d times", calls)
+	}
+}
+
+func TestOnceUnsubscribe(t *testing.T) {
+	emitter := New()
+
+	calls := 0
+	unsubscribe := emitter.Once("event", func(args ...interface{}) { calls++ })
+	unsubscribe()
+	emitter.Emit("event")
+
+	if calls != 0 {
+		t.Errorf("unsubscribed once listener is called %d times", calls)
+	}
+	if n := emitter.ListenerCount("event"); n != 0 {
+		t.Errorf("ListenerCount is %d, expected 0", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	emitter := New()
+
+	// closures of the same literal are distinct listeners
+	calls := []int{}
+	unsubscribes := []func(){}
+	for i := 0; i < 3; i++ {
+		i := i
+		unsubscribes = append(unsubscribes, emitter.On("event", func(args ...interface{}) {
+			calls = append(calls, i)
+		}))
+	}
+
+	unsubscribes[1]()
+	unsubscribes[1]()
+	emitter.Emit("event")
+
+	if !reflect.DeepEqual(calls, []int{0, 2}) {
+		t.Errorf("called listeners %v, expected [0 2]", calls)
+	}
+	if n := emitter.ListenerCount("event"); n != 2 {
+		t.Errorf("ListenerCount is %d, expected 2", n)
+	}
+
+	unsubscribes[0]()
+	unsubscribes[2]()
+	if names := emitter.EventNames(); len(names) != 0 {
+		t.Errorf("EventNames is %v after all listeners are removed", names)
+	}
+}
+
+func TestReentrant(t *testing.T) {
+	emitter := New()
+
+	calls := 0
+	emitter.On("event", func(args ...interface{}) {
+		calls++
+		// added listener is called from the next emit
+		emitter.On("event", func(args ...interface{}) { calls++ })
+		emitter.Emit("other")
+	})
+	emitter.Emit("event")
+
+	if calls != 1 {
+		t.Errorf("listeners are called %d times, expected 1", calls)
+	}
+	if n := emitter.ListenerCount("event"); n != 2 {
+		t.Errorf("ListenerCount is %d, expected 2", n)
+	}
+}
+
+func TestPrepend(t *testing.T) {
+	emitter := New()
+
+	calls := []string{}
+	emitter.On("b", func(args ...interface{}) { calls = append(calls, "on") })
+	emitter.Prepend("b", func(args ...interface{}) { calls = append(calls, "prepend") })
+	emitter.On("a", func(args ...interface{}) {})
+	emitter.Emit("b")
+
+	if !reflect.DeepEqual(calls, []string{"prepend", "on"}) {
+		t.Errorf("listeners are called in order %v", calls)
+	}
+	if names := emitter.EventNames(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("EventNames is %v, expected [a b]", names)
+	}
+}
+
+func TestSetMaxListeners(t *testing.T) {
+	output := &bytes.Buffer{}
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(output)
+
+	emitter := New()
+	emitter.SetMaxListeners(2)
+	for i := 0; i < 4; i++ {
+		emitter.On("event", func(args ...interface{}) {})
+	}
+	if n := strings.Count(output.String(), "possible leak"); n != 1 {
+		t.Errorf("leak is warned %d times, expected once, log is %q", n, output.String())
+	}
+	if !strings.Contains(output.String(), `3 listeners of event "event"`) {
+		t.Errorf("warning is %q", output.String())
+	}
+
+	output.Reset()
+	emitter.SetMaxListeners(0)
+	for i := 0; i < 4; i++ {
+		emitter.On("other", func(args ...interface{}) {})
+	}
+	if output.Len() != 0 {
+		t.Errorf("leak is warned with no max, log is %q", output.String())
+	}
+}
+
+func removedListener(args ...interface{}) {}
+
+func TestRemoveListener(t *testing.T) {
+	emitter := New()
+
+	calls := 0
+	emitter.On("event", removedListener)
+	emitter.On("event", func(args ...interface{}) { calls++ })
+	emitter.On("event", removedListener)
+
+	emitter.RemoveListener("event", removedListener)
+	if n := emitter.ListenerCount("event"); n != 2 {
+		t.Errorf("ListenerCount is %d, expected 2", n)
+	}
+	emitter.RemoveListener("event", removedListener)
+	emitter.Emit("event")
+	if calls != 1 || emitter.ListenerCount("event") != 1 {
+		t.Errorf("other listener is called %d times and %d listeners remain", calls, emitter.ListenerCount("event"))
+	}
+}
@@ -0,0 +1,23 @@
+package engineio
+
+import "net/http"
+
+func (opt *CookieOptions) cookie(sid string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     opt.Name,
+		Value:    sid,
+		Path:     opt.Path,
+		HttpOnly: opt.HttpOnly,
+		SameSite: opt.SameSite,
+		Secure:   opt.Secure,
+		MaxAge:   opt.MaxAge,
+	}
+
+	if cookie.Name == "" {
+		cookie.Name = "io"
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	return cookie
+}
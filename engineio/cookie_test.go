@@ -0,0 +1,41 @@
+package engineio
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCookieOnHandshakeOnly(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{Cookie: &CookieOptions{HttpOnly: true}})
+
+	resp := pollingRequest(t, ts, http.MethodGet, "", "")
+	body := readBody(t, resp)
+
+	cookies := resp.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("handshake response sets %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != "io" || cookie.Path != "/" || !cookie.HttpOnly {
+		t.Errorf("cookie = %v, want io with path / and HttpOnly", cookie)
+	}
+	if !strings.Contains(body, `"sid":"`+cookie.Value+`"`) {
+		t.Errorf("cookie value %q is not sid of handshake %q", cookie.Value, body)
+	}
+
+	// requests of the session do not set cookie
+	sid := cookie.Value
+	post := pollingRequest(t, ts, http.MethodPost, "&sid="+sid, "6")
+	readBody(t, post)
+	if cookies := post.Cookies(); len(cookies) != 0 {
+		t.Errorf("POST response sets cookies %v", cookies)
+	}
+
+	server.getSocket(sid).Send("message")
+	get := pollingRequest(t, ts, http.MethodGet, "&sid="+sid, "")
+	readBody(t, get)
+	if cookies := get.Cookies(); len(cookies) != 0 {
+		t.Errorf("GET response sets cookies %v", cookies)
+	}
+}
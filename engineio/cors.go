@@ -0,0 +1,89 @@
+package engineio
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CORSOptions struct {
+	// Origins is list of allowed origins, "*" allows any origin
+	Origins []string
+
+	// AllowOrigin checks origin, used when Origins is empty
+	AllowOrigin func(origin string) bool
+
+	Credentials    bool
+	AllowedHeaders []string
+
+	// MaxAge is how long in seconds preflight response can be cached
+	MaxAge int
+}
+
+func (cors *CORSOptions) isOriginAllowed(origin string) bool {
+	if cors.AllowOrigin != nil && len(cors.Origins) == 0 {
+		return cors.AllowOrigin(origin)
+	}
+
+	for _, allowed := range cors.Origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *CORSOptions) isAnyOrigin() bool {
+	for _, allowed := range cors.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCORS set CORS headers of response. It returns false if origin is
+// rejected.
+func (server *Server) handleCORS(w http.ResponseWriter, req *http.Request) bool {
+	cors := server.options.CORS
+	if cors == nil {
+		return true
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if !cors.isOriginAllowed(origin) {
+		return false
+	}
+
+	header := w.Header()
+	if cors.isAnyOrigin() && !cors.Credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+
+	if cors.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if req.Method != http.MethodOptions {
+		return true
+	}
+
+	// preflight
+	header.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if len(cors.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	} else if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package engineio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	_, ts := newTestServer(t, EngineIOOptions{CORS: &CORSOptions{
+		Origins:     []string{"https://example.com"},
+		Credentials: true,
+		MaxAge:      600,
+	}})
+
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+"/engine.io/?EIO=4&transport=polling", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Headers", "X-Token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST, OPTIONS",
+		"Access-Control-Allow-Headers":     "X-Token",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	}
+	for key, value := range want {
+		if got := resp.Header.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestCORSOrigin(t *testing.T) {
+	tests := []struct {
+		name       string
+		cors       *CORSOptions
+		origin     string
+		wantStatus int
+		wantOrigin string
+	}{
+		{"Disabled", nil, "https://evil.com", http.StatusOK, ""},
+		{"No origin", &CORSOptions{Origins: []string{"https://example.com"}}, "", http.StatusOK, ""},
+		{"Allowed", &CORSOptions{Origins: []string{"https://example.com"}}, "https://example.com", http.StatusOK, "https://example.com"},
+		{"Denied", &CORSOptions{Origins: []string{"https://example.com"}}, "https://evil.com", http.StatusForbidden, ""},
+		{"Any", &CORSOptions{Origins: []string{"*"}}, "https://evil.com", http.StatusOK, "*"},
+		{"Any with credentials", &CORSOptions{Origins: []string{"*"}, Credentials: true}, "https://evil.com", http.StatusOK, "https://evil.com"},
+		{
+			"AllowOrigin", &CORSOptions{AllowOrigin: func(origin string) bool { return origin == "https://example.com" }},
+			"https://example.com", http.StatusOK, "https://example.com",
+		},
+		{
+			"AllowOrigin denied", &CORSOptions{AllowOrigin: func(origin string) bool { return origin == "https://example.com" }},
+			"https://evil.com", http.StatusForbidden, "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := newTestServer(t, EngineIOOptions{CORS: tt.cors})
+
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/engine.io/?EIO=4&transport=polling", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBody(t, resp)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+func TestWebsocketCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		cors   *CORSOptions
+		origin string
+		want   bool
+	}{
+		{"Disabled", nil, "https://evil.com", true},
+		{"No origin", &CORSOptions{Origins: []string{"https://example.com"}}, "", true},
+		{"Allowed", &CORSOptions{Origins: []string{"https://example.com"}}, "https://example.com", true},
+		{"Denied", &CORSOptions{Origins: []string{"https://example.com"}}, "https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upgrader := newWebsocketUpgrader(EngineIOOptions{CORS: tt.cors})
+
+			req := httptest.NewRequest(http.MethodGet, "/engine.io/?EIO=4&transport=websocket", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := upgrader.CheckOrigin(req); got != tt.want {
+				t.Errorf("CheckOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,127 @@
 package engineio
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+	"time"
+)
 
 type ContextKey byte
 
-const ctxKeySocket ContextKey = 0x12
+// UPGRADE_TIMEOUT is how long client may take to upgrade transport
+const UPGRADE_TIMEOUT = 10 * time.Second
+
+type EngineIOOptions struct {
+	PingInterval int
+	PingTimeout  int
+
+	// MaxHTTPBufferSize is max bytes of a polling request body or a websocket
+	// message. It is also sent to client as maxPayload. Default is 1MB.
+	MaxHTTPBufferSize int
+
+	// PerMessageDeflate enables permessage-deflate extension of websocket
+	// transport. Nil means disabled.
+	PerMessageDeflate *CompressionOptions
+
+	// HTTPCompression enables gzip/deflate compression of polling responses.
+	// Nil means disabled.
+	HTTPCompression *CompressionOptions
+
+	// CORS enables CORS headers and origin checks of polling and websocket
+	// handshakes. Nil means disabled.
+	CORS *CORSOptions
+
+	// Cookie is set on handshake response with session id as value, e.g. for
+	// sticky sessions. Nil means no cookie.
+	Cookie *CookieOptions
+
+	// AllowEIO3 accepts Engine.IO protocol v3 clients (socket.io-client 2.x)
+	AllowEIO3 bool
+
+	// DisableJSONP rejects JSONP polling requests (j query parameter)
+	DisableJSONP bool
+
+	// OutboxSize is max number of messages queued to a socket. Default is 4.
+	OutboxSize int
+
+	// OutboxPolicy is what to do when outbox of a socket is full
+	OutboxPolicy OutboxPolicy
+
+	// DispatchWorkers is max number of sockets whose handlers run
+	// concurrently. Handlers of a socket always run in order. Default is 256.
+	DispatchWorkers int
 
-type TransportType byte
+	// DispatchQueueSize is max number of handlers queued to a socket. When
+	// it is full, packets of the socket are not read until its handlers catch
+	// up. Default is 64.
+	DispatchQueueSize int
 
+	// TrustProxy takes client address and scheme of handshake from
+	// X-Forwarded-For and X-Forwarded-Proto headers set by reverse proxy
+	TrustProxy bool
+}
+
+type CookieOptions struct {
+	Name     string // default is "io"
+	Path     string // default is "/"
+	HttpOnly bool
+	SameSite http.SameSite
+	Secure   bool
+	MaxAge   int // seconds, 0 means session cookie
+}
+
+type CompressionOptions struct {
+	// Threshold is minimum size in bytes of a message to be compressed
+	Threshold int
+}
+
+// SendOptions modify how a message is sent by Socket.SendWithOptions
+type SendOptions struct {
+	// NoCompress skips compression, e.g. for already compressed data
+	NoCompress bool
+
+	// WaitWritten blocks until message is written to transport. It fails
+	// with ErrPacketDropped if outbox policy drops the message and with
+	// ErrSocketClosed if socket closes before.
+	WaitWritten bool
+
+	// Written receives result of writing message like WaitWritten, without
+	// blocking sender. It must have room for one error and is not closed.
+	// Socket closing may not be received, so receiver watches its context.
+	// Not used with WaitWritten or Volatile.
+	Written chan error
+
+	// Volatile drops message instead of queueing it when transport is not
+	// writable or outbox is full. Dropped message returns ErrPacketDropped.
+	Volatile bool
+}
+
+const DEFAULT_MAX_HTTP_BUFFER_SIZE int = 1e6
+
+// Error codes of handshake response
 const (
-	TRANSPORT_POLLING   TransportType = 0
-	TRANSPORT_WEBSOCKET TransportType = 1
+	ERROR_UNKNOWN_TRANSPORT            = 0
+	ERROR_UNKNOWN_SID                  = 1
+	ERROR_BAD_HANDSHAKE_METHOD         = 2
+	ERROR_BAD_REQUEST                  = 3
+	ERROR_FORBIDDEN                    = 4
+	ERROR_UNSUPPORTED_PROTOCOL_VERSION = 5
 )
 
-type EngineIOOptions struct {
-	PingInterval int
-	PingTimeout  int
+var errorMessages = map[int]string{
+	ERROR_UNKNOWN_TRANSPORT:            "Transport unknown",
+	ERROR_UNKNOWN_SID:                  "Session ID unknown",
+	ERROR_BAD_HANDSHAKE_METHOD:         "Bad handshake method",
+	ERROR_BAD_REQUEST:                  "Bad request",
+	ERROR_FORBIDDEN:                    "Forbidden",
+	ERROR_UNSUPPORTED_PROTOCOL_VERSION: "Unsupported protocol version",
 }
 
 var ErrSocketClosed = errors.New("Socket closed")
 var ErrTimeout = errors.New("Socket timeout")
 var ErrPingTimeout = errors.New("Socket ping timeout")
 var ErrMessageNotSupported = errors.New("message not supported")
+var ErrTransportError = errors.New("transport error")
+var ErrTransportClose = errors.New("transport close")
+var ErrPacketDropped = errors.New("packet dropped")
+var ErrOutboxFull = errors.New("outbox full")
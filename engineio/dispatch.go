@@ -0,0 +1,108 @@
+package engineio
+
+import (
+	"sync"
+)
+
+// DEFAULT_DISPATCH_WORKERS is default max number of sockets whose handlers
+// run concurrently
+const DEFAULT_DISPATCH_WORKERS int = 256
+
+// DEFAULT_DISPATCH_QUEUE_SIZE is default max number of handlers queued to a
+// socket
+const DEFAULT_DISPATCH_QUEUE_SIZE int = 64
+
+// dispatcher runs handlers of sockets using bounded pool of workers, so
+// reading and heartbeat of sockets are not blocked by application code until
+// queue of a socket is full.
+// Handlers of a socket run in order, one at a time. Workers exit when there
+// is nothing to run.
+type dispatcher struct {
+	mtx        *sync.Mutex
+	ready      []*Socket // sockets having queued handlers
+	workers    int
+	maxWorkers int
+}
+
+func newDispatcher(maxWorkers int) *dispatcher {
+	return &dispatcher{
+		mtx:        &sync.Mutex{},
+		ready:      []*Socket{},
+		maxWorkers: maxWorkers,
+	}
+}
+
+// schedule queues socket to be run by a worker
+func (d *dispatcher) schedule(socket *Socket) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.ready = append(d.ready, socket)
+	if d.workers < d.maxWorkers {
+		d.workers++
+		go d.work()
+	}
+}
+
+func (d *dispatcher) work() {
+	for {
+		d.mtx.Lock()
+		if len(d.ready) == 0 {
+			d.workers--
+			d.mtx.Unlock()
+			return
+		}
+		socket := d.ready[0]
+		d.ready[0] = nil
+		d.ready = d.ready[1:]
+		d.mtx.Unlock()
+
+		socket.runDispatched()
+	}
+}
+
+// dispatch queues handler f of socket. It waits while queue is full, so
+// reading of slow socket is blocked and client waits too. f is dropped if
+// socket closes meanwhile.
+func (socket *Socket) dispatch(f func()) {
+	select {
+	case socket.dispatchSlots <- struct{}{}:
+	case <-socket.ctx.Done():
+		return
+	}
+
+	socket.dispatchMtx.Lock()
+	socket.dispatchQueue = append(socket.dispatchQueue, f)
+	if socket.isDispatching {
+		socket.dispatchMtx.Unlock()
+		return
+	}
+	socket.isDispatching = true
+	socket.dispatchMtx.Unlock()
+
+	socket.server.dispatcher.schedule(socket)
+}
+
+// runDispatched runs queued handlers of socket. If more handlers are queued
+// meanwhile, socket is scheduled again so other sockets get their turn.
+func (socket *Socket) runDispatched() {
+	socket.dispatchMtx.Lock()
+	queue := socket.dispatchQueue
+	socket.dispatchQueue = nil
+	socket.dispatchMtx.Unlock()
+
+	for _, f := range queue {
+		socket.runSafely(f)
+		<-socket.dispatchSlots
+	}
+
+	socket.dispatchMtx.Lock()
+	if len(socket.dispatchQueue) == 0 {
+		socket.isDispatching = false
+		socket.dispatchMtx.Unlock()
+		return
+	}
+	socket.dispatchMtx.Unlock()
+
+	socket.server.dispatcher.schedule(socket)
+}
@@ -0,0 +1,114 @@
+package engineio
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	const maxWorkers = 2
+	server := &Server{dispatcher: newDispatcher(maxWorkers)}
+
+	var running, maxRunning int32
+	wg := &sync.WaitGroup{}
+	results := make([][]int, 5)
+
+	for i := range results {
+		socket := &Socket{
+			server:        server,
+			ctx:           context.Background(),
+			dispatchMtx:   &sync.Mutex{},
+			dispatchSlots: make(chan struct{}, 100),
+		}
+		i := i
+		for j := 0; j < 100; j++ {
+			j := j
+			wg.Add(1)
+			socket.dispatch(func() {
+				defer wg.Done()
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				results[i] = append(results[i], j)
+			})
+		}
+	}
+	wg.Wait()
+
+	if maxRunning > maxWorkers {
+		t.Errorf("%d handlers run concurrently, want at most %d", maxRunning, maxWorkers)
+	}
+	for i, result := range results {
+		for j, v := range result {
+			if v != j {
+				t.Fatalf("socket %d: handler %d run at %d", i, v, j)
+			}
+		}
+	}
+}
+
+func TestDispatchQueueFull(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{DispatchQueueSize: 2})
+
+	release := make(chan struct{})
+	received := make(chan interface{}, 10)
+	server.OnConnection(func(socket *Socket) {
+		socket.OnMessage(func(socket *Socket, message interface{}) {
+			<-release
+			received <- message
+		})
+	})
+	sid, _ := pollingHandshake(t, ts)
+
+	// read waits for handlers, so POST of next payload is not answered
+	if body := readBody(t, pollingRequest(t, ts, http.MethodPost, "&sid="+sid, "41\x1e42\x1e43\x1e44")); body != "ok" {
+		t.Fatalf("POST response is %q, want ok", body)
+	}
+	posted := make(chan string, 1)
+	go func() {
+		resp, err := http.Post(ts.URL+"/engine.io/?EIO=4&transport=polling&sid="+sid, "text/plain", strings.NewReader("45"))
+		if err != nil {
+			posted <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		posted <- string(b)
+	}()
+	select {
+	case body := <-posted:
+		t.Fatalf("POST to socket having full queue is answered %q", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case body := <-posted:
+		if body != "ok" {
+			t.Errorf("POST response is %q, want ok", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("POST is not answered after handlers catch up")
+	}
+	for _, want := range []string{"1", "2", "3", "4", "5"} {
+		select {
+		case message := <-received:
+			if message != want {
+				t.Errorf("received %v, want %v", message, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %v is not received", want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package engineio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Engine.IO protocol v3, used by socket.io-client 2.x
+
+const (
+	PROTOCOL_V3 int = 3
+	PROTOCOL_V4 int = 4
+)
+
+var errInvalidPayload = errors.New("invalid payload")
+
+// encodeV3 encodes packet as string. binary data is encoded as b4<base64>.
+func (p *Packet) encodeV3() string {
+	if p.packetType != PACKET_PAYLOAD {
+		return p.encode()
+	}
+	return string(PACKET_PAYLOAD) + string(PACKET_MESSAGE) + base64.StdEncoding.EncodeToString(p.data)
+}
+
+// encodeAsPayloadV3 encodes packet as <length>:<packet>, length is in
+// UTF-16 code units as counted by javascript client.
+func (p *Packet) encodeAsPayloadV3() string {
+	encoded := p.encodeV3()
+	return strconv.Itoa(len(utf16.Encode([]rune(encoded)))) + ":" + encoded
+}
+
+func decodeV3Packet(msg string) (*Packet, error) {
+	if len(msg) == 0 {
+		return nil, errInvalidPayload
+	}
+
+	if PacketType(msg[0]) == PACKET_PAYLOAD {
+		if len(msg) < 2 {
+			return nil, errInvalidPayload
+		}
+		data, err := base64.StdEncoding.DecodeString(msg[2:])
+		if err != nil {
+			return nil, err
+		}
+		return &Packet{packetType: PACKET_PAYLOAD, data: data}, nil
+	}
+
+	return &Packet{
+		packetType: PacketType(msg[0]),
+		data:       []byte(msg[1:]),
+	}, nil
+}
+
+// decodePayloadV3 decodes string payload <length>:<packet><length>:<packet>...
+func decodePayloadV3(b []byte) ([]*Packet, error) {
+	packets := []*Packet{}
+	runes := []rune(string(b))
+
+	for len(runes) > 0 {
+		sep := -1
+		for i, r := range runes {
+			if r == ':' {
+				sep = i
+				break
+			}
+		}
+		if sep <= 0 {
+			return nil, errInvalidPayload
+		}
+
+		length, err := strconv.Atoi(string(runes[:sep]))
+		if err != nil {
+			return nil, errInvalidPayload
+		}
+		runes = runes[sep+1:]
+
+		// length is in UTF-16 code units
+		end := 0
+		for units := 0; units < length; end++ {
+			if end >= len(runes) {
+				return nil, errInvalidPayload
+			}
+			units += len(utf16.Encode(runes[end : end+1]))
+		}
+
+		p, err := decodeV3Packet(string(runes[:end]))
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+		runes = runes[end:]
+	}
+	return packets, nil
+}
+
+// decodeBinaryPayloadV3 decodes binary payload sent as application/octet-stream.
+// Each packet is <0 string|1 binary><length digits><0xFF><packet>.
+func decodeBinaryPayloadV3(b []byte) ([]*Packet, error) {
+	packets := []*Packet{}
+	buf := bytes.NewBuffer(b)
+
+	for buf.Len() > 0 {
+		isBinary, _ := buf.ReadByte()
+
+		length := 0
+		for {
+			digit, err := buf.ReadByte()
+			if err != nil {
+				return nil, errInvalidPayload
+			}
+			if digit == 0xFF {
+				break
+			}
+			if digit > 9 {
+				return nil, errInvalidPayload
+			}
+			length = length*10 + int(digit)
+		}
+
+		data := buf.Next(length)
+		if len(data) != length || length == 0 {
+			return nil, errInvalidPayload
+		}
+
+		if isBinary == 1 {
+			// first byte is packet type
+			packets = append(packets, &Packet{packetType: PACKET_PAYLOAD, data: data[1:]})
+			continue
+		}
+
+		p, err := decodeV3Packet(string(data))
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+	return packets, nil
+}
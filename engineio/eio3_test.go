@@ -0,0 +1,192 @@
+package engineio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_decodePayloadV3(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want []*Packet
+	}{
+		{
+			name: "Message packets",
+			b:    []byte(`6:4hello2:4€`),
+			want: []*Packet{
+				{packetType: PACKET_MESSAGE, data: []byte("hello")},
+				{packetType: PACKET_MESSAGE, data: []byte("€")},
+			},
+		},
+		{
+			name: "Ping packet",
+			b:    []byte(`1:2`),
+			want: []*Packet{
+				{packetType: PACKET_PING, data: []byte{}},
+			},
+		},
+		{
+			name: "Base64 binary packet",
+			b:    []byte(`10:b4AQIDBA==`),
+			want: []*Packet{
+				{packetType: PACKET_PAYLOAD, data: []byte{1, 2, 3, 4}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePayloadV3(tt.b)
+			if err != nil {
+				t.Errorf("decodePayloadV3() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodePayloadV3() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeBinaryPayloadV3(t *testing.T) {
+	b := []byte{0, 6, 0xFF}
+	b = append(b, []byte("4hello")...)
+	b = append(b, 1, 5, 0xFF, 4, 1, 2, 3, 4)
+
+	want := []*Packet{
+		{packetType: PACKET_MESSAGE, data: []byte("hello")},
+		{packetType: PACKET_PAYLOAD, data: []byte{1, 2, 3, 4}},
+	}
+
+	got, err := decodeBinaryPayloadV3(b)
+	if err != nil {
+		t.Errorf("decodeBinaryPayloadV3() error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeBinaryPayloadV3() = %v, want %v", got, want)
+	}
+}
+
+func Test_packet_encodeAsPayloadV3(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *Packet
+		want string
+	}{
+		{"Message packet", NewPacket(PACKET_MESSAGE, []byte("€")), "2:4€"},
+		{"Binary packet", NewPacket(PACKET_PAYLOAD, []byte{1, 2, 3, 4}), "10:b4AQIDBA=="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.encodeAsPayloadV3(); got != tt.want {
+				t.Errorf("encodeAsPayloadV3() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pollingRequestV3 sends polling request of protocol v3 with query appended
+func pollingRequestV3(t *testing.T, ts *httptest.Server, method, query, body string) *http.Response {
+	t.Helper()
+
+	url := ts.URL + "/engine.io/?EIO=3&transport=polling" + query
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// pollingHandshakeV3 opens polling session of protocol v3 and returns its id
+// and data of OPEN packet
+func pollingHandshakeV3(t *testing.T, ts *httptest.Server) (string, map[string]interface{}) {
+	t.Helper()
+
+	body := readBody(t, pollingRequestV3(t, ts, http.MethodGet, "", ""))
+	packets, err := decodePayloadV3([]byte(body))
+	if err != nil || len(packets) != 1 || packets[0].packetType != PACKET_OPEN {
+		t.Fatalf("handshake response is %q, want OPEN packet", body)
+	}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(packets[0].data, &data); err != nil {
+		t.Fatal(err)
+	}
+	sid, _ := data["sid"].(string)
+	return sid, data
+}
+
+func TestPollingHandshakeV3(t *testing.T) {
+	_, rejecting := newTestServer(t, EngineIOOptions{})
+	if resp := pollingRequestV3(t, rejecting, http.MethodGet, "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("handshake of protocol v3 status = %d without AllowEIO3, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	server, ts := newTestServer(t, EngineIOOptions{AllowEIO3: true})
+	received := make(chan interface{}, 1)
+	server.OnConnection(func(socket *Socket) {
+		socket.OnMessage(func(socket *Socket, message interface{}) {
+			received <- message
+		})
+	})
+
+	sid, open := pollingHandshakeV3(t, ts)
+	if sid == "" {
+		t.Errorf("OPEN packet %v has no sid", open)
+	}
+	if _, isFound := open["maxPayload"]; isFound {
+		t.Errorf("OPEN packet of protocol v3 has maxPayload %v", open["maxPayload"])
+	}
+
+	if body := readBody(t, pollingRequestV3(t, ts, http.MethodPost, "&sid="+sid, "6:4hello")); body != "ok" {
+		t.Fatalf("POST response is %q, want ok", body)
+	}
+	select {
+	case message := <-received:
+		if message != "hello" {
+			t.Errorf("received %v, want hello", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message is not received")
+	}
+}
+
+func TestHeartbeatV3(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{AllowEIO3: true, PingInterval: 200, PingTimeout: 100})
+	sid, _ := pollingHandshakeV3(t, ts)
+	socket := onlySocket(t, server)
+
+	// client pings and server answers pong
+	time.Sleep(200 * time.Millisecond)
+	if body := readBody(t, pollingRequestV3(t, ts, http.MethodPost, "&sid="+sid, "1:2")); body != "ok" {
+		t.Fatalf("POST response is %q, want ok", body)
+	}
+	if body := readBody(t, pollingRequestV3(t, ts, http.MethodGet, "&sid="+sid, "")); body != "1:3" {
+		t.Fatalf("GET response is %q, want pong", body)
+	}
+
+	// ping extends deadline of handshake
+	time.Sleep(200 * time.Millisecond)
+	if socket.Context().Err() != nil {
+		t.Fatalf("socket is closed %v after ping", socket.CloseReason())
+	}
+
+	// server does not ping, socket closes when client stops pinging
+	if err := waitClosed(t, socket); err != ErrPingTimeout {
+		t.Errorf("close reason is %v, want %v", err, ErrPingTimeout)
+	}
+}
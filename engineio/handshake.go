@@ -0,0 +1,48 @@
+package engineio
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Handshake is details of HTTP request that created a socket
+type Handshake struct {
+	Headers   http.Header
+	Query     url.Values
+	Address   string // remote IP address of client
+	Secure    bool   // whether connection uses TLS
+	Issued    time.Time
+	URL       string
+	Transport string // transport of handshake request, e.g. "polling"
+}
+
+func newHandshake(req *http.Request, options EngineIOOptions) Handshake {
+	handshake := Handshake{
+		Headers:   req.Header.Clone(),
+		Query:     req.URL.Query(),
+		Address:   req.RemoteAddr,
+		Secure:    req.TLS != nil,
+		Issued:    time.Now(),
+		URL:       req.URL.String(),
+		Transport: req.URL.Query().Get("transport"),
+	}
+
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		handshake.Address = host
+	}
+
+	if options.TrustProxy {
+		// first address is the client, the rest are proxies
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			handshake.Address = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+			handshake.Secure = proto == "https" || proto == "wss"
+		}
+	}
+
+	return handshake
+}
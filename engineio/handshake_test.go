@@ -0,0 +1,46 @@
+package engineio
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  string
+		proto      string
+		address    string
+		secure     bool
+	}{
+		{"direct", false, "", "", "192.0.2.1", false},
+		{"untrusted proxy", false, "203.0.113.5", "https", "192.0.2.1", false},
+		{"trusted proxy", true, "203.0.113.5, 10.0.0.1", "https", "203.0.113.5", true},
+		{"trusted proxy without headers", true, "", "", "192.0.2.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/socket.io/?EIO=4&transport=polling&token=abc", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			handshake := newHandshake(req, EngineIOOptions{TrustProxy: tt.trustProxy})
+			if handshake.Address != tt.address {
+				t.Errorf("address is %q, want %q", handshake.Address, tt.address)
+			}
+			if handshake.Secure != tt.secure {
+				t.Errorf("secure is %v, want %v", handshake.Secure, tt.secure)
+			}
+			if handshake.Transport != "polling" || handshake.Query.Get("token") != "abc" {
+				t.Errorf("unexpected handshake %+v", handshake)
+			}
+		})
+	}
+}
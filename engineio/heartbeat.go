@@ -0,0 +1,221 @@
+package engineio
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// heartbeat drives pings and ping timeouts of all sockets of a server using a
+// min-heap of deadlines and one goroutine, instead of timers per socket.
+//
+// In protocol v4 server sends ping every pingInterval and waits pong for
+// pingTimeout. In protocol v3 client sends ping and server closes socket if
+// there is no ping for pingInterval + pingTimeout.
+type heartbeat struct {
+	mtx       *sync.Mutex
+	queue     heartbeatQueue
+	wake      chan struct{}
+	isRunning bool
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+}
+
+// heartbeatState is heartbeat state of a socket, guarded by heartbeat.mtx
+type heartbeatState struct {
+	deadline   time.Time
+	index      int // in queue, -1 if not scheduled
+	isPinging  bool
+	pingSentAt time.Time
+}
+
+type heartbeatQueue []*Socket
+
+func (q heartbeatQueue) Len() int { return len(q) }
+
+func (q heartbeatQueue) Less(i, j int) bool {
+	return q[i].heartbeat.deadline.Before(q[j].heartbeat.deadline)
+}
+
+func (q heartbeatQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].heartbeat.index = i
+	q[j].heartbeat.index = j
+}
+
+func (q *heartbeatQueue) Push(x interface{}) {
+	socket := x.(*Socket)
+	socket.heartbeat.index = len(*q)
+	*q = append(*q, socket)
+}
+
+func (q *heartbeatQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	socket := old[n-1]
+	old[n-1] = nil
+	socket.heartbeat.index = -1
+	*q = old[:n-1]
+	return socket
+}
+
+func newHeartbeat(pingInterval, pingTimeout time.Duration) *heartbeat {
+	return &heartbeat{
+		mtx:          &sync.Mutex{},
+		queue:        heartbeatQueue{},
+		wake:         make(chan struct{}, 1),
+		pingInterval: pingInterval,
+		pingTimeout:  pingTimeout,
+	}
+}
+
+// add starts heartbeat of socket
+func (h *heartbeat) add(socket *Socket) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	socket.heartbeat.index = -1
+	if socket.protocol == PROTOCOL_V3 {
+		h.schedule(socket, time.Now().Add(h.pingInterval+h.pingTimeout))
+	} else {
+		h.schedule(socket, time.Now().Add(h.pingInterval))
+	}
+
+	if !h.isRunning {
+		h.isRunning = true
+		go h.run()
+	}
+}
+
+// remove stops heartbeat of socket
+func (h *heartbeat) remove(socket *Socket) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if i := socket.heartbeat.index; i >= 0 {
+		heap.Remove(&h.queue, i)
+	}
+}
+
+// onPong handles pong sent by client in protocol v4
+func (h *heartbeat) onPong(socket *Socket) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if !socket.heartbeat.isPinging || socket.heartbeat.index < 0 {
+		return
+	}
+	socket.heartbeat.isPinging = false
+
+	next := socket.heartbeat.pingSentAt.Add(h.pingInterval)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	h.schedule(socket, next)
+}
+
+// onPing handles ping sent by client in protocol v3
+func (h *heartbeat) onPing(socket *Socket) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if socket.heartbeat.index < 0 {
+		return
+	}
+	h.schedule(socket, time.Now().Add(h.pingInterval+h.pingTimeout))
+}
+
+// schedule sets deadline of socket, h.mtx must be locked
+func (h *heartbeat) schedule(socket *Socket, deadline time.Time) {
+	socket.heartbeat.deadline = deadline
+	if socket.heartbeat.index >= 0 {
+		heap.Fix(&h.queue, socket.heartbeat.index)
+	} else {
+		heap.Push(&h.queue, socket)
+	}
+
+	// wake run if deadline is the earliest
+	if socket.heartbeat.index == 0 {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *heartbeat) run() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		h.mtx.Lock()
+		now := time.Now()
+		due := []*Socket{}
+		for len(h.queue) > 0 && !h.queue[0].heartbeat.deadline.After(now) {
+			due = append(due, heap.Pop(&h.queue).(*Socket))
+		}
+
+		wait := time.Duration(-1)
+		if len(h.queue) > 0 {
+			wait = h.queue[0].heartbeat.deadline.Sub(now)
+		}
+		h.mtx.Unlock()
+
+		if len(due) > 0 {
+			for _, socket := range due {
+				h.fireSafely(socket)
+			}
+			continue
+		}
+
+		if wait < 0 {
+			<-h.wake
+			continue
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-h.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// fireSafely fires socket and reports its panic, so heartbeat of other
+// sockets keeps running
+func (h *heartbeat) fireSafely(socket *Socket) {
+	defer socket.server.recoverPanic(socket)
+	h.fire(socket)
+}
+
+// fire handles reached deadline of socket
+func (h *heartbeat) fire(socket *Socket) {
+	h.mtx.Lock()
+	// closed after popped
+	if socket.ctx.Err() != nil {
+		h.mtx.Unlock()
+		return
+	}
+
+	if socket.protocol == PROTOCOL_V3 || socket.heartbeat.isPinging {
+		h.mtx.Unlock()
+		go socket.closeWithError(ErrPingTimeout)
+		return
+	}
+
+	now := time.Now()
+	socket.heartbeat.isPinging = true
+	socket.heartbeat.pingSentAt = now
+	h.schedule(socket, now.Add(h.pingTimeout))
+	h.mtx.Unlock()
+
+	// control packet is queued without blocking
+	socket.sendPacket(NewPacket(PACKET_PING, []byte{}))
+}
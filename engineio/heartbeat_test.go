@@ -0,0 +1,132 @@
+package engineio
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestHeartbeatSocket(protocol int) *Socket {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	return &Socket{
+		protocol:      protocol,
+		outbox:        newOutbox(DEFAULT_OUTBOX_SIZE, OUTBOX_BLOCK, new(uint64)),
+		ctx:           ctx,
+		ctxCancelFunc: cancelFunc,
+		heartbeat:     heartbeatState{index: -1},
+	}
+}
+
+func TestHeartbeatPing(t *testing.T) {
+	h := newHeartbeat(10*time.Millisecond, time.Hour)
+	socket := newTestHeartbeatSocket(PROTOCOL_V4)
+	h.add(socket)
+	defer h.remove(socket)
+
+	select {
+	case <-socket.outbox.notEmpty:
+	case <-time.After(time.Second):
+		t.Fatal("ping is not sent")
+	}
+
+	p, _ := socket.outbox.tryPop()
+	if p == nil || p.packetType != PACKET_PING {
+		t.Fatalf("got %v, want ping", p)
+	}
+
+	h.mtx.Lock()
+	isPinging := socket.heartbeat.isPinging
+	h.mtx.Unlock()
+	if !isPinging {
+		t.Fatal("socket is not pinging")
+	}
+
+	h.onPong(socket)
+
+	h.mtx.Lock()
+	isPinging = socket.heartbeat.isPinging
+	h.mtx.Unlock()
+	if isPinging {
+		t.Fatal("socket is still pinging after pong")
+	}
+}
+
+func TestHeartbeatRemove(t *testing.T) {
+	h := newHeartbeat(time.Hour, time.Hour)
+	sockets := []*Socket{}
+	for i := 0; i < 10; i++ {
+		socket := newTestHeartbeatSocket(PROTOCOL_V4)
+		h.add(socket)
+		sockets = append(sockets, socket)
+	}
+
+	for _, socket := range sockets {
+		h.remove(socket)
+		if socket.heartbeat.index != -1 {
+			t.Fatalf("index is %d after removed", socket.heartbeat.index)
+		}
+	}
+	if len(h.queue) != 0 {
+		t.Fatalf("queue has %d sockets after removed", len(h.queue))
+	}
+}
+
+// BenchmarkHeartbeatShared schedules heartbeat of b.N idle sockets using
+// shared scheduler.
+func BenchmarkHeartbeatShared(b *testing.B) {
+	h := newHeartbeat(time.Hour, time.Hour)
+	sockets := make([]*Socket, b.N)
+	for i := range sockets {
+		sockets[i] = newTestHeartbeatSocket(PROTOCOL_V4)
+	}
+	goroutines := runtime.NumGoroutine()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, socket := range sockets {
+		h.add(socket)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/op")
+	for _, socket := range sockets {
+		h.remove(socket)
+	}
+}
+
+// BenchmarkHeartbeatPerSocket schedules heartbeat of b.N idle sockets using
+// goroutine and timers per socket, as done before shared scheduler.
+func BenchmarkHeartbeatPerSocket(b *testing.B) {
+	sockets := make([]*Socket, b.N)
+	for i := range sockets {
+		sockets[i] = newTestHeartbeatSocket(PROTOCOL_V4)
+	}
+	goroutines := runtime.NumGoroutine()
+	started := make(chan struct{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, socket := range sockets {
+		go func(socket *Socket) {
+			pingIntervalTimer := time.NewTimer(time.Hour)
+			pingTimeoutTimer := time.NewTimer(time.Hour)
+			defer pingIntervalTimer.Stop()
+			defer pingTimeoutTimer.Stop()
+			started <- struct{}{}
+
+			select {
+			case <-socket.ctx.Done():
+			case <-pingIntervalTimer.C:
+			case <-pingTimeoutTimer.C:
+			}
+		}(socket)
+		<-started
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/op")
+	for _, socket := range sockets {
+		socket.ctxCancelFunc()
+	}
+}
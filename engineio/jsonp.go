@@ -0,0 +1,56 @@
+package engineio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// JSONP polling for browsers which can not use XHR to server, response is
+// wrapped as ___eio[j]("payload"); and request is form-encoded d=payload.
+
+var (
+	errJSONPNoData       = errors.New("jsonp: no data")
+	jsonpNonDigits       = regexp.MustCompile(`[^0-9]`)
+	jsonpEscapedNewlines = regexp.MustCompile(`(\\)?\\n`)
+)
+
+func isJSONP(req *http.Request) bool {
+	_, isFound := req.URL.Query()["j"]
+	return isFound
+}
+
+func jsonpWrap(j string, payload []byte) []byte {
+	// json.Marshal escapes U+2028 and U+2029 which are invalid in javascript
+	js, _ := json.Marshal(string(payload))
+
+	buf := strings.Builder{}
+	buf.WriteString("___eio[")
+	buf.WriteString(jsonpNonDigits.ReplaceAllString(j, ""))
+	buf.WriteString("](")
+	buf.Write(js)
+	buf.WriteString(");")
+	return []byte(buf.String())
+}
+
+func jsonpUnwrap(body []byte) ([]byte, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if _, isFound := values["d"]; !isFound {
+		return nil, errJSONPNoData
+	}
+
+	// client escapes newlines as \n and \n as \\n
+	data := jsonpEscapedNewlines.ReplaceAllStringFunc(values.Get("d"), func(match string) string {
+		if len(match) == 3 {
+			return match
+		}
+		return "\n"
+	})
+	return []byte(strings.ReplaceAll(data, `\\n`, `\n`)), nil
+}
@@ -0,0 +1,96 @@
+package engineio
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_jsonpWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		j       string
+		payload string
+		want    string
+	}{
+		{"Message", "0", `4hello`, `___eio[0]("4hello");`},
+		{"Quotes", "12", `4"hi"`, `___eio[12]("4\"hi\"");`},
+		{"Non-digit index", "1);alert(1", `4`, `___eio[11]("4");`},
+		{"Line separators", "0", "4\u2028\u2029", `___eio[0]("4\u2028\u2029");`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(jsonpWrap(tt.j, []byte(tt.payload))); got != tt.want {
+				t.Errorf("jsonpWrap() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jsonpUnwrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error
+	}{
+		{"Message", "d=" + url.QueryEscape("4hello"), "4hello", nil},
+		{"Escaped newline", "d=" + url.QueryEscape(`4a\nb`), "4a\nb", nil},
+		{"Escaped backslash n", "d=" + url.QueryEscape(`4a\\nb`), `4a\nb`, nil},
+		{"No data", "x=4hello", "", errJSONPNoData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonpUnwrap([]byte(tt.body))
+			if err != tt.wantErr {
+				t.Fatalf("jsonpUnwrap() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("jsonpUnwrap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPHandshake(t *testing.T) {
+	_, ts := newTestServer(t, EngineIOOptions{})
+
+	resp := pollingRequest(t, ts, http.MethodGet, "&j=0", "")
+	body := readBody(t, resp)
+	if !strings.HasPrefix(body, `___eio[0]("0{`) {
+		t.Errorf("handshake response is %s, want wrapped OPEN packet", body)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") {
+		t.Errorf("Content-Type = %q, want text/javascript", got)
+	}
+}
+
+func TestDisableJSONP(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{
+		DisableJSONP: true,
+		Cookie:       &CookieOptions{},
+	})
+
+	resp := pollingRequest(t, ts, http.MethodGet, "&j=0", "")
+	body := readBody(t, resp)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if !strings.Contains(body, `"code":3`) {
+		t.Errorf("response is %s, want error code %d", body, ERROR_BAD_REQUEST)
+	}
+	if cookies := resp.Cookies(); len(cookies) != 0 {
+		t.Errorf("rejected handshake sets cookies %v", cookies)
+	}
+
+	server.socketsMtx.Lock()
+	n := len(server.sockets)
+	server.socketsMtx.Unlock()
+	if n != 0 {
+		t.Errorf("rejected handshake creates %d sockets", n)
+	}
+}
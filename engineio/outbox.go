@@ -0,0 +1,241 @@
+package engineio
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutboxPolicy is what to do with a message sent to a socket whose outbox is
+// full, i.e. client does not read fast enough.
+type OutboxPolicy byte
+
+const (
+	// OUTBOX_BLOCK blocks sender until outbox has room or send timeout
+	OUTBOX_BLOCK OutboxPolicy = iota
+	// OUTBOX_DROP_NEWEST drops sent message
+	OUTBOX_DROP_NEWEST
+	// OUTBOX_DROP_OLDEST drops oldest queued message to make room, or sent
+	// message if only control packets are queued
+	OUTBOX_DROP_OLDEST
+	// OUTBOX_DISCONNECT closes the socket of slow client
+	OUTBOX_DISCONNECT
+)
+
+const DEFAULT_OUTBOX_SIZE int = 4
+
+// outbox is queue of packets waiting to be sent by transport. Control packets
+// (ping, pong, open, etc) are always queued so heartbeat is not blocked.
+type outbox struct {
+	mtx      *sync.Mutex
+	packets  []*Packet
+	size     int
+	policy   OutboxPolicy
+	isClosed bool
+
+	// notEmpty is signaled when packet is pushed
+	notEmpty chan struct{}
+
+	// notFull is closed and replaced when packet is popped
+	notFull chan struct{}
+
+	dropped      *uint64
+	totalDropped *uint64 // of server
+}
+
+func newOutbox(size int, policy OutboxPolicy, totalDropped *uint64) *outbox {
+	return &outbox{
+		mtx:      &sync.Mutex{},
+		packets:  []*Packet{},
+		size:     size,
+		policy:   policy,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}),
+		dropped:  new(uint64),
+
+		totalDropped: totalDropped,
+	}
+}
+
+func isControlPacket(p *Packet) bool {
+	return p.packetType != PACKET_MESSAGE && p.packetType != PACKET_PAYLOAD
+}
+
+// push queues packet according to policy. It returns ErrPacketDropped if
+// packet is dropped and ErrOutboxFull if socket must be disconnected.
+func (q *outbox) push(p *Packet, done <-chan struct{}, timeout time.Duration) error {
+	return q.pushAll([]*Packet{p}, done, timeout)
+}
+
+// pushAll queues packets which must not be separated according to policy, as
+// one unit: they are queued, dropped or waited together and no other packet
+// is queued between them. Unit larger than outbox is queued when outbox is
+// empty.
+func (q *outbox) pushAll(packets []*Packet, done <-chan struct{}, timeout time.Duration) error {
+	var timer *time.Timer
+	var timerChan <-chan time.Time
+
+	q.mtx.Lock()
+	for {
+		if q.isClosed {
+			q.mtx.Unlock()
+			return ErrSocketClosed
+		}
+
+		if q.hasRoom(len(packets)) || isControlPacket(packets[0]) {
+			q.appendAll(packets)
+			q.mtx.Unlock()
+			return nil
+		}
+
+		switch q.policy {
+		case OUTBOX_DROP_NEWEST:
+			q.mtx.Unlock()
+			q.drop(len(packets))
+			return ErrPacketDropped
+
+		case OUTBOX_DROP_OLDEST:
+			for q.dropOldest() {
+				if q.hasRoom(len(packets)) {
+					q.appendAll(packets)
+					q.mtx.Unlock()
+					return nil
+				}
+			}
+
+			// only control packets are queued, drop sent one
+			q.mtx.Unlock()
+			q.drop(len(packets))
+			return ErrPacketDropped
+
+		case OUTBOX_DISCONNECT:
+			q.mtx.Unlock()
+			return ErrOutboxFull
+		}
+
+		// block
+		notFull := q.notFull
+		q.mtx.Unlock()
+
+		if timer == nil && timeout > 0 {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+			timerChan = timer.C
+		}
+
+		select {
+		case <-notFull:
+		case <-done:
+			return ErrSocketClosed
+		case <-timerChan:
+			return ErrTimeout
+		}
+		q.mtx.Lock()
+	}
+}
+
+// hasRoom reports whether n packets can be queued
+func (q *outbox) hasRoom(n int) bool {
+	return len(q.packets)+n <= q.size || len(q.packets) == 0
+}
+
+// dropOldest drops oldest queued message with packets attached to it. It
+// returns false if only control packets and attachments of popped message
+// are queued.
+func (q *outbox) dropOldest() bool {
+	for i, queued := range q.packets {
+		if isControlPacket(queued) || queued.isAttached {
+			continue
+		}
+
+		j := i + 1
+		for j < len(q.packets) && q.packets[j].isAttached {
+			j++
+		}
+		dropped := append([]*Packet{}, q.packets[i:j]...)
+		q.packets = append(q.packets[:i], q.packets[j:]...)
+		q.drop(len(dropped))
+		for _, p := range dropped {
+			p.done(ErrPacketDropped)
+		}
+		return true
+	}
+	return false
+}
+
+// tryPush queues packets only if outbox has room for all of them
+func (q *outbox) tryPush(packets ...*Packet) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.isClosed || len(q.packets)+len(packets) > q.size {
+		return false
+	}
+	q.appendAll(packets)
+	return true
+}
+
+// appendAll appends packets, marking ones after the first as attached to it
+func (q *outbox) appendAll(packets []*Packet) {
+	for i, p := range packets {
+		p.isAttached = i > 0
+		q.append(p)
+	}
+}
+
+func (q *outbox) append(p *Packet) {
+	q.packets = append(q.packets, p)
+	select {
+	case q.notEmpty <- struct{}{}:
+	default:
+	}
+}
+
+// tryPop pops first packet if any
+func (q *outbox) tryPop() (p *Packet, isFound bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.packets) == 0 {
+		return nil, false
+	}
+
+	p = q.packets[0]
+	q.packets[0] = nil
+	q.packets = q.packets[1:]
+
+	close(q.notFull)
+	q.notFull = make(chan struct{})
+	return p, true
+}
+
+// peek returns first packet without popping it
+func (q *outbox) peek() (p *Packet, isFound bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.packets) == 0 {
+		return nil, false
+	}
+	return q.packets[0], true
+}
+
+// close closes outbox and fails waiters of queued packets
+func (q *outbox) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.isClosed = true
+	for _, p := range q.packets {
+		p.done(ErrSocketClosed)
+	}
+}
+
+func (q *outbox) drop(n int) {
+	atomic.AddUint64(q.dropped, uint64(n))
+	atomic.AddUint64(q.totalDropped, uint64(n))
+}
+
+func (q *outbox) droppedCount() uint64 {
+	return atomic.LoadUint64(q.dropped)
+}
@@ -0,0 +1,320 @@
+package engineio
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_outbox_push(t *testing.T) {
+	message := func(data string) *Packet {
+		return NewPacket(PACKET_MESSAGE, []byte(data))
+	}
+
+	tests := []struct {
+		name        string
+		policy      OutboxPolicy
+		wantErr     error
+		wantQueued  []string
+		wantDropped uint64
+	}{
+		{"Block", OUTBOX_BLOCK, ErrTimeout, []string{"1", "2"}, 0},
+		{"Drop newest", OUTBOX_DROP_NEWEST, ErrPacketDropped, []string{"1", "2"}, 1},
+		{"Drop oldest", OUTBOX_DROP_OLDEST, nil, []string{"2", "3"}, 1},
+		{"Disconnect", OUTBOX_DISCONNECT, ErrOutboxFull, []string{"1", "2"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutbox(2, tt.policy, new(uint64))
+			done := make(chan struct{})
+
+			q.push(message("1"), done, 0)
+			q.push(message("2"), done, 0)
+			if err := q.push(message("3"), done, 10*time.Millisecond); err != tt.wantErr {
+				t.Errorf("push() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// control packet is always queued
+			if err := q.push(NewPacket(PACKET_PING, []byte{}), done, 10*time.Millisecond); err != nil {
+				t.Errorf("push() ping error = %v", err)
+			}
+
+			for _, want := range tt.wantQueued {
+				if p, _ := q.tryPop(); p == nil || string(p.data) != want {
+					t.Errorf("tryPop() = %v, want %v", p, want)
+				}
+			}
+			if p, _ := q.tryPop(); p == nil || p.packetType != PACKET_PING {
+				t.Errorf("tryPop() = %v, want ping", p)
+			}
+			if got := q.droppedCount(); got != tt.wantDropped {
+				t.Errorf("droppedCount() = %v, want %v", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func Test_outbox_dropOldestControlPackets(t *testing.T) {
+	q := newOutbox(2, OUTBOX_DROP_OLDEST, new(uint64))
+	done := make(chan struct{})
+
+	q.push(NewPacket(PACKET_PING, []byte{}), done, 0)
+	q.push(NewPacket(PACKET_PONG, []byte{}), done, 0)
+
+	// control packets are never dropped, so sent message is
+	if err := q.push(NewPacket(PACKET_MESSAGE, []byte("1")), done, 0); err != ErrPacketDropped {
+		t.Errorf("push() error = %v, want %v", err, ErrPacketDropped)
+	}
+	if got := len(q.packets); got != 2 {
+		t.Errorf("outbox has %d packets, want 2", got)
+	}
+	if got := q.droppedCount(); got != 1 {
+		t.Errorf("droppedCount() = %v, want 1", got)
+	}
+}
+
+func Test_outbox_pushAll(t *testing.T) {
+	message := func(data string) *Packet {
+		return NewPacket(PACKET_MESSAGE, []byte(data))
+	}
+	attachment := func(data string) *Packet {
+		return NewPacket(PACKET_PAYLOAD, []byte(data))
+	}
+
+	tests := []struct {
+		name        string
+		policy      OutboxPolicy
+		wantErr     error
+		wantQueued  []string
+		wantDropped uint64
+	}{
+		{"Drop newest", OUTBOX_DROP_NEWEST, ErrPacketDropped, []string{"1", "1a", "2"}, 2},
+		{"Drop oldest", OUTBOX_DROP_OLDEST, nil, []string{"2", "3", "3a"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutbox(3, tt.policy, new(uint64))
+			done := make(chan struct{})
+
+			// header and its attachment are queued, dropped and popped together
+			q.pushAll([]*Packet{message("1"), attachment("1a")}, done, 0)
+			q.push(message("2"), done, 0)
+			if err := q.pushAll([]*Packet{message("3"), attachment("3a")}, done, 0); err != tt.wantErr {
+				t.Errorf("pushAll() error = %v, want %v", err, tt.wantErr)
+			}
+
+			for _, want := range tt.wantQueued {
+				if p, _ := q.tryPop(); p == nil || string(p.data) != want {
+					t.Errorf("tryPop() = %v, want %v", p, want)
+				}
+			}
+			if p, isFound := q.tryPop(); isFound {
+				t.Errorf("tryPop() = %v, want empty outbox", p)
+			}
+			if got := q.droppedCount(); got != tt.wantDropped {
+				t.Errorf("droppedCount() = %v, want %v", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func Test_outbox_dropOldestPopped(t *testing.T) {
+	q := newOutbox(2, OUTBOX_DROP_OLDEST, new(uint64))
+	done := make(chan struct{})
+
+	q.pushAll([]*Packet{NewPacket(PACKET_MESSAGE, []byte("1")), NewPacket(PACKET_PAYLOAD, []byte("1a"))}, done, 0)
+	q.tryPop()
+	q.push(NewPacket(PACKET_MESSAGE, []byte("2")), done, 0)
+
+	// attachment of popped message is not dropped without it
+	q.push(NewPacket(PACKET_MESSAGE, []byte("3")), done, 0)
+	for _, want := range []string{"1a", "3"} {
+		if p, _ := q.tryPop(); p == nil || string(p.data) != want {
+			t.Errorf("tryPop() = %v, want %v", p, want)
+		}
+	}
+}
+
+func TestSendAllConcurrently(t *testing.T) {
+	server := NewServer(EngineIOOptions{OutboxSize: 2, OutboxPolicy: OUTBOX_DROP_OLDEST})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			socket.SendAllWithOptions([]interface{}{"header", []byte{1}, []byte{2}}, SendOptions{})
+		}()
+	}
+	wg.Wait()
+
+	// only whole groups are left
+	want := []PacketType{PACKET_MESSAGE, PACKET_PAYLOAD, PACKET_PAYLOAD}
+	for i := 0; ; i++ {
+		p, isFound := socket.outbox.tryPop()
+		if !isFound {
+			if i%len(want) != 0 {
+				t.Errorf("outbox has %d packets, want whole groups", i)
+			}
+			break
+		}
+		if p.packetType != want[i%len(want)] {
+			t.Fatalf("packet %d is of type %c, want %c", i, p.packetType, want[i%len(want)])
+		}
+	}
+}
+
+func TestSendWaitWritten(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  OutboxPolicy
+		release func(socket *Socket)
+		wantErr error
+	}{
+		{
+			"Written", OUTBOX_BLOCK,
+			func(socket *Socket) {
+				p, _ := socket.outbox.tryPop()
+				p.done(nil)
+			},
+			nil,
+		},
+		{
+			"Dropped", OUTBOX_DROP_OLDEST,
+			func(socket *Socket) { socket.Send("newer") },
+			ErrPacketDropped,
+		},
+		{
+			"Outbox closed", OUTBOX_BLOCK,
+			func(socket *Socket) { socket.outbox.close() },
+			ErrSocketClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(EngineIOOptions{OutboxSize: 1, OutboxPolicy: tt.policy})
+			socket := newSocket(server, PROTOCOL_V4, context.Background())
+
+			result := make(chan error, 1)
+			go func() {
+				// zero timeout waits forever
+				result <- socket.SendWithOptions("message", SendOptions{WaitWritten: true})
+			}()
+
+			for i := 0; ; i++ {
+				if _, isFound := socket.outbox.peek(); isFound {
+					break
+				}
+				if i == 100 {
+					t.Fatal("message is not queued")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			tt.release(socket)
+
+			select {
+			case err := <-result:
+				if err != tt.wantErr {
+					t.Errorf("SendWithOptions() error = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("SendWithOptions() is not released")
+			}
+		})
+	}
+}
+
+func TestSendAllWritten(t *testing.T) {
+	server := NewServer(EngineIOOptions{})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+
+	written := make(chan error, 1)
+	if err := socket.SendAllWithOptions([]interface{}{"message", []byte{1}}, SendOptions{Written: written}); err != nil {
+		t.Fatal(err)
+	}
+
+	// result is of the last message
+	first, _ := socket.outbox.tryPop()
+	first.done(nil)
+	select {
+	case err := <-written:
+		t.Fatalf("written receives %v of first message", err)
+	default:
+	}
+
+	last, _ := socket.outbox.tryPop()
+	last.done(nil)
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("written receives %v, want nil", err)
+		}
+	default:
+		t.Fatal("written does not receive result of last message")
+	}
+}
+
+func Test_outbox_tryPush(t *testing.T) {
+	message := NewPacket(PACKET_MESSAGE, []byte("1"))
+
+	q := newOutbox(3, OUTBOX_BLOCK, new(uint64))
+	if !q.tryPush(message, message) {
+		t.Error("tryPush() of 2 packets to empty outbox fails")
+	}
+	if q.tryPush(message, message) {
+		t.Error("tryPush() of 2 packets to outbox having room for 1 succeeds")
+	}
+	if got := len(q.packets); got != 2 {
+		t.Errorf("outbox has %d packets, want 2", got)
+	}
+	if !q.tryPush(message) {
+		t.Error("tryPush() of 1 packet to outbox having room for 1 fails")
+	}
+
+	q.tryPop()
+	q.close()
+	if q.tryPush(message) {
+		t.Error("tryPush() to closed outbox succeeds")
+	}
+}
+
+func TestSendVolatile(t *testing.T) {
+	server := NewServer(EngineIOOptions{OutboxSize: 2})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+	transport := newPollingTransport(socket).(*pollingTransport)
+	socket.transport = transport
+
+	volatile := SendOptions{Volatile: true}
+	if err := socket.SendWithOptions("1", volatile); err != ErrPacketDropped {
+		t.Errorf("SendWithOptions() to unwritable transport error = %v, want %v", err, ErrPacketDropped)
+	}
+
+	// GET is waiting
+	atomic.StoreInt32(transport.waiting, 1)
+	if err := socket.SendWithOptions("1", volatile); err != nil {
+		t.Errorf("SendWithOptions() to writable transport error = %v", err)
+	}
+
+	// packet and its attachments are dropped together
+	if err := socket.SendAllWithOptions([]interface{}{"2", []byte{1}}, volatile); err != ErrPacketDropped {
+		t.Errorf("SendAllWithOptions() error = %v, want %v", err, ErrPacketDropped)
+	}
+	if err := socket.SendAllWithOptions([]interface{}{"3"}, volatile); err != nil {
+		t.Errorf("SendAllWithOptions() error = %v", err)
+	}
+
+	for _, want := range []string{"1", "3"} {
+		if p, _ := socket.outbox.tryPop(); p == nil || string(p.data) != want {
+			t.Errorf("tryPop() = %v, want %v", p, want)
+		}
+	}
+	if p, isFound := socket.outbox.tryPop(); isFound {
+		t.Errorf("tryPop() = %v, want empty outbox", p)
+	}
+}
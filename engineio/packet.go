@@ -6,35 +6,75 @@ import (
 	"io"
 )
 
-type eioPacketType byte
+// PacketType is type of Engine.IO packet, e.g. PACKET_MESSAGE
+type PacketType byte
 
 const (
-	PACKET_OPEN    eioPacketType = '0'
-	PACKET_CLOSE   eioPacketType = '1'
-	PACKET_PING    eioPacketType = '2'
-	PACKET_PONG    eioPacketType = '3'
-	PACKET_MESSAGE eioPacketType = '4'
-	PACKET_UPGRADE eioPacketType = '5'
-	PACKET_NOOP    eioPacketType = '6'
-	PACKET_PAYLOAD eioPacketType = 'b'
+	PACKET_OPEN    PacketType = '0'
+	PACKET_CLOSE   PacketType = '1'
+	PACKET_PING    PacketType = '2'
+	PACKET_PONG    PacketType = '3'
+	PACKET_MESSAGE PacketType = '4'
+	PACKET_UPGRADE PacketType = '5'
+	PACKET_NOOP    PacketType = '6'
+	PACKET_PAYLOAD PacketType = 'b'
 )
 
 const DELIMITER byte = 0x1E
 
-type packet struct {
-	packetType eioPacketType
+type Packet struct {
+	packetType PacketType
 	data       []byte
-	callback   chan bool
+	callback   chan error // receives nil when packet is written to transport, or why it is not
+	noCompress bool
+	isAttached bool // queued with packet before it, e.g. binary attachment
 }
 
-func NewPacket(packetType eioPacketType, data []byte) *packet {
-	return &packet{
+func NewPacket(packetType PacketType, data []byte) *Packet {
+	return &Packet{
 		packetType: packetType,
 		data:       data,
 	}
 }
 
-func (p *packet) encode() string {
+// Type returns packet type
+func (p *Packet) Type() PacketType {
+	return p.packetType
+}
+
+// Data returns packet data, binary message data is not base64 encoded
+func (p *Packet) Data() []byte {
+	return p.data
+}
+
+// Encode encodes packet as Engine.IO v4 string packet
+func (p *Packet) Encode() string {
+	return p.encode()
+}
+
+// done reports result of sending packet to its waiter, if any
+func (p *Packet) done(err error) {
+	if p.callback == nil {
+		return
+	}
+	select {
+	case p.callback <- err:
+	default:
+	}
+}
+
+// NoCompress reports whether packet is sent without compression
+func (p *Packet) NoCompress() bool {
+	return p.noCompress
+}
+
+// DecodePacket decodes Engine.IO v4 string packet, e.g. text frame of
+// websocket. Binary packet is base64 encoded and prefixed by 'b'.
+func DecodePacket(s string) (*Packet, error) {
+	return decodeAsEngineIOPacket(bytes.NewBufferString(s))
+}
+
+func (p *Packet) encode() string {
 	buf := bytes.Buffer{}
 	if p.packetType != PACKET_PAYLOAD {
 		buf.WriteByte(byte(p.packetType))
@@ -49,8 +89,8 @@ func (p *packet) encode() string {
 
 // Decode stream buffer to engineIOPacket.
 // isPayload default is false.
-func decodeAsEngineIOPacket(buf *bytes.Buffer) (*packet, error) {
-	var p *packet
+func decodeAsEngineIOPacket(buf *bytes.Buffer) (*Packet, error) {
+	var p *Packet
 
 	packetType, err := buf.ReadByte()
 
@@ -58,8 +98,8 @@ func decodeAsEngineIOPacket(buf *bytes.Buffer) (*packet, error) {
 		return nil, err
 	}
 
-	p = &packet{
-		packetType: eioPacketType(packetType),
+	p = &Packet{
+		packetType: PacketType(packetType),
 	}
 
 	p.data, err = buf.ReadBytes(DELIMITER)
@@ -2,68 +2,284 @@ package engineio
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+type pollingTransport struct {
+	socket    *Socket
+	waiting   *int32 // number of waiting GET requests
+	sendChan  chan pollingBatch
+	recvChan  chan []*Packet
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// pollingBatch is packets waiting for GET request
+type pollingBatch struct {
+	packets []*Packet
+	result  chan error
+}
+
+func newPollingTransport(socket *Socket) Transport {
+	return &pollingTransport{
+		socket:    socket,
+		waiting:   new(int32),
+		sendChan:  make(chan pollingBatch),
+		recvChan:  make(chan []*Packet),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+func (t *pollingTransport) Name() string {
+	return "polling"
+}
+
+func (t *pollingTransport) SupportsFraming() bool {
+	return false
+}
+
 // Handle transport polling
-func ServePolling(w http.ResponseWriter, req *http.Request) {
-	socket, isSocketFound := req.Context().Value(ctxKeySocket).(*Socket)
+func (t *pollingTransport) HandleRequest(w http.ResponseWriter, req *http.Request) {
+	socket := t.socket
 
 	switch req.Method {
 	// listener: packet sender
 	case "GET":
-		if !isSocketFound {
-			packet := NewPacket(PACKET_CLOSE, []byte{})
-			w.Write([]byte(packet.encode()))
+		atomic.AddInt32(t.waiting, 1)
+		defer atomic.AddInt32(t.waiting, -1)
+
+		select {
+		case batch := <-t.sendChan:
+			batch.result <- socket.writePollingPayload(w, req, batch.packets)
+
+		// release client so it can finish upgrading
+		case <-socket.upgrading():
+			t.writeSinglePacket(w, req, NewPacket(PACKET_NOOP, []byte{}))
+
+		case <-t.closed:
+			if socket.Transport() != Transport(t) {
+				t.writeSinglePacket(w, req, NewPacket(PACKET_NOOP, []byte{}))
+			} else {
+				t.writeSinglePacket(w, req, NewPacket(PACKET_CLOSE, []byte{}))
+			}
+
+		case <-req.Context().Done():
+			socket.closeWithError(ErrTransportClose)
+		}
+
+	// listener: packet reciever
+	case "POST":
+		maxSize := socket.server.options.MaxHTTPBufferSize
+		if req.ContentLength > int64(maxSize) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			socket.closeWithError(ErrTransportError)
 			return
 		}
 
-		if socket.Transport != TRANSPORT_POLLING {
-			if _, err := w.Write([]byte(NewPacket(PACKET_NOOP, []byte{}).encode())); err != nil {
-				socket.close()
+		b, err := io.ReadAll(io.LimitReader(req.Body, int64(maxSize)+1))
+		if err != nil {
+			return
+		}
+		if len(b) > maxSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			socket.closeWithError(ErrTransportError)
+			return
+		}
+		contentType := req.Header.Get("Content-Type")
+		if isJSONP(req) {
+			if b, err = jsonpUnwrap(b); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 				return
 			}
+			contentType = "text/plain"
+		}
+
+		packets, err := socket.DecodePayload(b, contentType)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			socket.closeWithError(ErrTransportError)
 			return
 		}
 
-		socket.isPollingWaiting = true
 		select {
-		case <-req.Context().Done():
-			socket.close()
+		case t.recvChan <- packets:
+		case <-t.closed:
+		}
 
-		case packet := <-socket.outbox:
-			if _, err := w.Write([]byte(packet.encode())); err != nil {
-				socket.close()
-				return
-			}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("ok"))
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *pollingTransport) writeSinglePacket(w http.ResponseWriter, req *http.Request, p *Packet) {
+	payload := t.socket.encodePollingPacket(p)
+	t.socket.writePollingResponse(w, req, []byte(payload), false)
+}
+
+// Send waits GET request and writes packets as its response
+func (t *pollingTransport) Send(packets []*Packet) error {
+	batch := pollingBatch{
+		packets: packets,
+		result:  make(chan error, 1),
+	}
+
+	select {
+	case t.sendChan <- batch:
+		return <-batch.result
+
+	case <-t.closed:
+		return errTransportClosed
+	}
+}
+
+// Writable reports whether a GET request is waiting packets
+func (t *pollingTransport) Writable() bool {
+	return atomic.LoadInt32(t.waiting) > 0
+}
+
+// Receive waits packets of POST request
+func (t *pollingTransport) Receive() ([]*Packet, error) {
+	select {
+	case packets := <-t.recvChan:
+		return packets, nil
+
+	case <-t.closed:
+		return nil, errTransportClosed
+	}
+}
+
+func (t *pollingTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+func (socket *Socket) encodePollingPacket(p *Packet) string {
+	if socket.protocol == PROTOCOL_V3 {
+		return p.encodeAsPayloadV3()
+	}
+	return p.encode()
+}
+
+// DecodePayload decodes payload of packets sent by client using transport
+// without framing in protocol of socket. Content type tells binary payload
+// of protocol v3.
+func (socket *Socket) DecodePayload(b []byte, contentType string) ([]*Packet, error) {
+	if socket.protocol == PROTOCOL_V3 {
+		if strings.HasPrefix(contentType, "application/octet-stream") {
+			return decodeBinaryPayloadV3(b)
 		}
-		socket.isPollingWaiting = false
+		return decodePayloadV3(b)
+	}
 
-	// listener: packet reciever
-	case "POST":
-		b, err := io.ReadAll(req.Body)
+	packets := []*Packet{}
+	buf := bytes.NewBuffer(b)
+	for buf.Len() > 0 {
+		packet, err := decodeAsEngineIOPacket(buf)
 		if err != nil {
-			return
+			return nil, err
 		}
-		buf := bytes.NewBuffer(b)
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
 
-		for {
-			if buf.Len() == 0 {
-				break
-			}
-			packet, _ := decodeAsEngineIOPacket(buf)
-			select {
-			case <-socket.ctx.Done():
-				goto postDone
+// EncodePayload encodes packets as payload of transport without framing in
+// protocol of socket
+func (socket *Socket) EncodePayload(packets []*Packet) []byte {
+	buf := bytes.Buffer{}
+	for i, p := range packets {
+		if i > 0 && socket.protocol != PROTOCOL_V3 {
+			buf.WriteByte(DELIMITER)
+		}
+		buf.WriteString(socket.encodePollingPacket(p))
+	}
+	return buf.Bytes()
+}
+
+// writePollingPayload writes packets as a payload. Payload is compressed
+// only if all of its packets are compressible, see Socket.batch.
+func (socket *Socket) writePollingPayload(w http.ResponseWriter, req *http.Request, packets []*Packet) error {
+	compress := true
+	for _, p := range packets {
+		compress = compress && !p.noCompress
+	}
+	return socket.writePollingResponse(w, req, socket.EncodePayload(packets), compress)
+}
 
-			case socket.inbox <- packet:
-				continue
+func (socket *Socket) writePollingResponse(w http.ResponseWriter, req *http.Request, payload []byte, compress bool) error {
+	if isJSONP(req) {
+		payload = jsonpWrap(req.URL.Query().Get("j"), payload)
+		w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	}
+
+	opt := socket.server.options.HTTPCompression
+	if opt == nil || !compress || len(payload) < opt.Threshold {
+		_, err := w.Write(payload)
+		return err
+	}
+
+	var cw io.WriteCloser
+	encoding := acceptedEncoding(req.Header.Get("Accept-Encoding"))
+	switch encoding {
+	case "gzip":
+		cw = gzip.NewWriter(w)
+	case "deflate":
+		cw, _ = flate.NewWriter(w, flate.DefaultCompression)
+	default:
+		_, err := w.Write(payload)
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if _, err := cw.Write(payload); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// acceptedEncoding returns gzip or deflate, whichever has higher q-value in
+// Accept-Encoding header, or empty string if none is accepted. On equal
+// q-values the first listed is chosen.
+func acceptedEncoding(header string) string {
+	encoding := ""
+	maxQ := 0.0
+
+	for _, accepted := range strings.Split(header, ",") {
+		params := strings.Split(accepted, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
 			}
 		}
 
-	postDone:
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte("ok"))
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name == "gzip" || name == "deflate") && q > maxQ {
+			encoding, maxQ = name, q
+		}
 	}
+	return encoding
 }
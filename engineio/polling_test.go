@@ -0,0 +1,124 @@
+package engineio
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_acceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip", "deflate"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;q=0", ""},
+		{"identity, *;q=0.1", "gzip"},
+		{"br, GZIP", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := acceptedEncoding(tt.header); got != tt.want {
+				t.Errorf("acceptedEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollingCompression(t *testing.T) {
+	server := NewServer(EngineIOOptions{HTTPCompression: &CompressionOptions{Threshold: 1}})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+
+	message := func(data string, noCompress bool) *Packet {
+		p := NewPacket(PACKET_MESSAGE, []byte(data))
+		p.noCompress = noCompress
+		return p
+	}
+	done := make(chan struct{})
+	socket.outbox.push(message("1", false), done, 0)
+	socket.outbox.push(message("2", false), done, 0)
+	socket.outbox.push(message("3", true), done, 0)
+	socket.outbox.push(message("4", false), done, 0)
+
+	// responses of batches having packets sent with Compress(false) are not
+	// compressed
+	want := []struct {
+		payload  string
+		encoding string
+	}{
+		{"41\x1e42", "gzip"},
+		{"43", ""},
+		{"44", "gzip"},
+	}
+
+	for _, want := range want {
+		first, _ := socket.outbox.tryPop()
+		packets := socket.batch([]*Packet{first})
+
+		req := httptest.NewRequest(http.MethodGet, "/engine.io/?EIO=4&transport=polling", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=1, deflate;q=0.5")
+		w := httptest.NewRecorder()
+		if err := socket.writePollingPayload(w, req, packets); err != nil {
+			t.Fatal(err)
+		}
+
+		body := io.Reader(w.Body)
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding == "gzip" {
+			gr, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = gr
+		}
+		payload, _ := io.ReadAll(body)
+
+		if string(payload) != want.payload || encoding != want.encoding {
+			t.Errorf("response is %q encoded %q, want %q encoded %q", payload, encoding, want.payload, want.encoding)
+		}
+	}
+}
+
+func TestPollingWritable(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{})
+
+	sid, _ := pollingHandshake(t, ts)
+	socket := server.getSocket(sid)
+	if socket.Transport().Writable() {
+		t.Error("Writable() = true without waiting GET")
+	}
+
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		if resp, err := http.Get(ts.URL + "/engine.io/?EIO=4&transport=polling&sid=" + sid); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	for i := 0; !socket.Transport().Writable(); i++ {
+		if i == 100 {
+			t.Fatal("Writable() = false with waiting GET")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	socket.Send("message")
+	<-polled
+	for i := 0; socket.Transport().Writable(); i++ {
+		if i == 100 {
+			t.Fatal("Writable() = true after GET is answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,59 @@
+package engineio
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is error of recovered panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprint("panic: ", err.Value)
+}
+
+// NewPanicError creates PanicError of recovered value v with current stack
+func NewPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// OnError add handler of panics recovered in handlers and goroutines of
+// sockets. Socket is nil if panic is not of a socket.
+func (server *Server) OnError(f func(*Socket, error)) {
+	server.handlers.error = f
+}
+
+// reportError calls error handler, ignoring its own panic
+func (server *Server) reportError(socket *Socket, err error) {
+	if server.handlers.error == nil {
+		return
+	}
+	defer func() { recover() }()
+	server.handlers.error(socket, err)
+}
+
+// recoverPanic recovers panic of socket handler and reports it. It must be
+// deferred directly.
+func (server *Server) recoverPanic(socket *Socket) {
+	if r := recover(); r != nil {
+		server.reportError(socket, NewPanicError(r))
+	}
+}
+
+// runSafely runs f and reports its panic
+func (socket *Socket) runSafely(f func()) {
+	defer socket.server.recoverPanic(socket)
+	f()
+}
+
+// recoverGoroutine recovers panic of socket goroutine, reports it and closes
+// socket. It must be deferred directly.
+func (socket *Socket) recoverGoroutine() {
+	if r := recover(); r != nil {
+		socket.server.reportError(socket, NewPanicError(r))
+		socket.closeWithError(ErrTransportError)
+	}
+}
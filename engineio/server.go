@@ -1,10 +1,12 @@
 package engineio
 
 import (
-	"context"
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,8 +17,19 @@ type Server struct {
 	sockets    map[uuid.UUID]*Socket
 	socketsMtx *sync.Mutex
 
+	transports     map[string]TransportFactory
+	transportNames []string // in registering order
+	transportsMtx  *sync.Mutex
+
+	droppedPackets *uint64
+	heartbeat      *heartbeat
+	dispatcher     *dispatcher
+
 	handlers struct {
-		connection func(*Socket)
+		connection     func(*Socket)
+		initialHeaders func(http.Header, *http.Request)
+		headers        func(http.Header, *http.Request)
+		error          func(*Socket, error)
 	}
 }
 
@@ -25,45 +38,196 @@ func NewServer(opt EngineIOOptions) (server *Server) {
 		options: EngineIOOptions{
 			PingInterval: opt.PingInterval,
 			PingTimeout:  opt.PingTimeout,
+
+			MaxHTTPBufferSize: opt.MaxHTTPBufferSize,
+			PerMessageDeflate: opt.PerMessageDeflate,
+			HTTPCompression:   opt.HTTPCompression,
+			CORS:              opt.CORS,
+			Cookie:            opt.Cookie,
+			AllowEIO3:         opt.AllowEIO3,
+			DisableJSONP:      opt.DisableJSONP,
+			OutboxSize:        opt.OutboxSize,
+			OutboxPolicy:      opt.OutboxPolicy,
+			DispatchWorkers:   opt.DispatchWorkers,
+			DispatchQueueSize: opt.DispatchQueueSize,
+			TrustProxy:        opt.TrustProxy,
 		},
 		sockets:    map[uuid.UUID]*Socket{},
 		socketsMtx: &sync.Mutex{},
+
+		transports:    map[string]TransportFactory{},
+		transportsMtx: &sync.Mutex{},
+
+		droppedPackets: new(uint64),
+		heartbeat: newHeartbeat(
+			time.Duration(opt.PingInterval)*time.Millisecond,
+			time.Duration(opt.PingTimeout)*time.Millisecond,
+		),
 	}
 
+	server.RegisterTransport("polling", newPollingTransport)
+	server.RegisterTransport("websocket", newWebsocketTransport)
+
+	if server.options.MaxHTTPBufferSize <= 0 {
+		server.options.MaxHTTPBufferSize = DEFAULT_MAX_HTTP_BUFFER_SIZE
+	}
+	if server.options.OutboxSize <= 0 {
+		server.options.OutboxSize = DEFAULT_OUTBOX_SIZE
+	}
+	if server.options.DispatchWorkers <= 0 {
+		server.options.DispatchWorkers = DEFAULT_DISPATCH_WORKERS
+	}
+	if server.options.DispatchQueueSize <= 0 {
+		server.options.DispatchQueueSize = DEFAULT_DISPATCH_QUEUE_SIZE
+	}
+	server.dispatcher = newDispatcher(server.options.DispatchWorkers)
+
 	return server
 }
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	version := req.URL.Query().Get("EIO")
-	v, err := strconv.Atoi(version)
-	if err != nil {
-		w.Write([]byte("Get version error"))
+	if !server.handleCORS(w, req) {
+		writeError(w, ERROR_FORBIDDEN)
 		return
 	}
-	if err != nil || v != 4 {
-		w.Write([]byte("Protocol version is not support"))
+
+	// preflight
+	if req.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// rejected before handshake creates a socket
+	if isJSONP(req) && server.options.DisableJSONP {
+		writeError(w, ERROR_BAD_REQUEST)
+		return
+	}
+
+	version := req.URL.Query().Get("EIO")
+	v, err := strconv.Atoi(version)
+	if err != nil || (v != PROTOCOL_V4 && !(v == PROTOCOL_V3 && server.options.AllowEIO3)) {
+		writeError(w, ERROR_UNSUPPORTED_PROTOCOL_VERSION)
 		return
 	}
 
 	sid := req.URL.Query().Get("sid")
 	transport := req.URL.Query().Get("transport")
 
-	socket := newSocket(server, sid)
-	ctxWithSocket := context.WithValue(req.Context(), ctxKeySocket, socket)
+	// handshake
+	if sid == "" {
+		if req.Method != http.MethodGet {
+			writeError(w, ERROR_BAD_HANDSHAKE_METHOD)
+			return
+		}
+
+		factory, isFound := server.transportFactory(transport)
+		if !isFound {
+			writeError(w, ERROR_UNKNOWN_TRANSPORT)
+			return
+		}
+
+		if server.handlers.initialHeaders != nil {
+			server.handlers.initialHeaders(w.Header(), req)
+		}
+		if server.handlers.headers != nil {
+			server.handlers.headers(w.Header(), req)
+		}
+
+		socket := newSocket(server, v, req.Context())
+		socket.handshake = newHandshake(req, server.options)
+		socket.transport = factory(socket)
 
-	if transport == "websocket" {
-		socket.Transport = TRANSPORT_WEBSOCKET
+		if server.options.Cookie != nil {
+			http.SetCookie(w, server.options.Cookie.cookie(socket.id.String()))
+		}
+
+		server.socketsMtx.Lock()
+		server.sockets[socket.id] = socket
+		server.socketsMtx.Unlock()
+
+		socket.start()
+		socket.transport.HandleRequest(w, withSocket(req, socket))
+		return
 	}
 
-	switch transport {
-	case "polling":
-		ServePolling(w, req.WithContext(ctxWithSocket))
+	if server.handlers.headers != nil {
+		server.handlers.headers(w.Header(), req)
+	}
+
+	socket := server.getSocket(sid)
+	if socket == nil {
+		writeError(w, ERROR_UNKNOWN_SID)
+		return
+	}
+
+	server.serveTransport(w, withSocket(req, socket), socket, transport)
+}
+
+// serveTransport serves request of socket using transport, upgrading socket
+// to it if it is not current transport
+func (server *Server) serveTransport(w http.ResponseWriter, req *http.Request, socket *Socket, transport string) {
+	current := socket.Transport()
+	if current.Name() == transport {
+		current.HandleRequest(w, req)
+		return
+	}
+
+	// upgrade transport
+	factory, isFound := server.transportFactory(transport)
+	if !isFound {
+		writeError(w, ERROR_UNKNOWN_TRANSPORT)
+		return
+	}
+	if current.SupportsFraming() {
+		writeError(w, ERROR_BAD_REQUEST)
+		return
+	}
 
-	case "websocket":
-		TransportWebsocketHandler.ServeHTTP(w, req.WithContext(ctxWithSocket))
+	upgrade := factory(socket)
+	upgrade.HandleRequest(w, req)
+	socket.upgrade(upgrade)
+}
+
+// DroppedPackets returns number of packets dropped by outbox policy of all sockets
+func (server *Server) DroppedPackets() uint64 {
+	return atomic.LoadUint64(server.droppedPackets)
+}
+
+func (server *Server) getSocket(sid string) *Socket {
+	uid, err := uuid.Parse(sid)
+	if err != nil {
+		return nil
 	}
+
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+	return server.sockets[uid]
 }
 
 func (server *Server) OnConnection(f func(*Socket)) {
 	server.handlers.connection = f
 }
+
+// OnInitialHeaders add handler to modify headers of handshake response
+func (server *Server) OnInitialHeaders(f func(http.Header, *http.Request)) {
+	server.handlers.initialHeaders = f
+}
+
+// OnHeaders add handler to modify headers of every response
+func (server *Server) OnHeaders(f func(http.Header, *http.Request)) {
+	server.handlers.headers = f
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	status := http.StatusBadRequest
+	if code == ERROR_FORBIDDEN {
+		status = http.StatusForbidden
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    code,
+		"message": errorMessages[code],
+	})
+}
@@ -0,0 +1,135 @@
+package engineio
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves server over http for tests
+func newTestServer(t *testing.T, opt EngineIOOptions) (*Server, *httptest.Server) {
+	t.Helper()
+
+	if opt.PingInterval == 0 {
+		opt.PingInterval = 25000
+	}
+	if opt.PingTimeout == 0 {
+		opt.PingTimeout = 20000
+	}
+	server := NewServer(opt)
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// pollingRequest sends polling request of protocol v4 with query appended
+func pollingRequest(t *testing.T, ts *httptest.Server, method, query, body string) *http.Response {
+	t.Helper()
+
+	url := ts.URL + "/engine.io/?EIO=4&transport=polling" + query
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// readBody reads and closes body of resp
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// pollingHandshake opens polling session and returns its id and data of OPEN
+// packet
+func pollingHandshake(t *testing.T, ts *httptest.Server) (string, map[string]interface{}) {
+	t.Helper()
+
+	body := readBody(t, pollingRequest(t, ts, http.MethodGet, "", ""))
+	if !strings.HasPrefix(body, string(PACKET_OPEN)) {
+		t.Fatalf("handshake response is %q, want OPEN packet", body)
+	}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body[1:]), &data); err != nil {
+		t.Fatal(err)
+	}
+	sid, _ := data["sid"].(string)
+	return sid, data
+}
+
+// waitClosed waits socket closed and returns its close reason
+func waitClosed(t *testing.T, socket *Socket) error {
+	t.Helper()
+
+	select {
+	case <-socket.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("socket is not closed")
+	}
+	return socket.CloseReason()
+}
+
+func TestHandshakeMaxPayload(t *testing.T) {
+	_, ts := newTestServer(t, EngineIOOptions{MaxHTTPBufferSize: 100})
+
+	_, open := pollingHandshake(t, ts)
+	if open["maxPayload"] != float64(100) {
+		t.Errorf("maxPayload = %v, want 100", open["maxPayload"])
+	}
+}
+
+func TestPollingMaxHTTPBufferSize(t *testing.T) {
+	body := "4" + strings.Repeat("a", 100)
+
+	tests := []struct {
+		name string
+		body io.Reader
+	}{
+		{"Content-Length", strings.NewReader(body)},
+		// unknown length is sent chunked and limited while reading
+		{"Chunked", io.MultiReader(strings.NewReader(body))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, ts := newTestServer(t, EngineIOOptions{MaxHTTPBufferSize: 100})
+
+			sid, _ := pollingHandshake(t, ts)
+			socket := server.getSocket(sid)
+			if socket == nil {
+				t.Fatal("socket is not registered")
+			}
+
+			url := ts.URL + "/engine.io/?EIO=4&transport=polling&sid=" + sid
+			resp, err := http.Post(url, "text/plain;charset=UTF-8", tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBody(t, resp)
+
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+			}
+			if err := waitClosed(t, socket); err != ErrTransportError {
+				t.Errorf("close reason = %v, want %v", err, ErrTransportError)
+			}
+		})
+	}
+}
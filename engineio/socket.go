@@ -13,155 +13,438 @@ type Socket struct {
 	server           *Server
 	mtx              *sync.Mutex
 	id               uuid.UUID
+	protocol         int
+	handshake        Handshake
 	IsConnected      bool
-	Transport        TransportType
-	inbox            chan *packet
-	outbox           chan *packet
-	isPollingWaiting bool
+	outbox           *outbox
 	IsReadingPayload bool
 
+	transport    Transport
+	transportMtx *sync.Mutex
+
+	// upgradingChan is closed when client probes new transport, isProbed
+	// is set meanwhile
+	upgradingChan chan struct{}
+	isProbed      bool
+
 	handlers struct {
 		message func(*Socket, interface{})
+		receive func(*Socket, interface{})
 		closed  func(*Socket)
 	}
 
 	ctx           context.Context
 	ctxCancelFunc context.CancelFunc
+	ctxValues     context.Context // values set by SetCtxValue
+	ctxValuesMtx  *sync.Mutex
+	closeReason   error
+	closeOnce     *sync.Once
+
+	// handlers queued to be run by server dispatcher
+	dispatchQueue []func()
+	isDispatching bool
+	dispatchMtx   *sync.Mutex
+	dispatchSlots chan struct{} // taken by queued and running handlers
+
+	heartbeat heartbeatState // guarded by server.heartbeat.mtx
 }
 
-func newSocket(server *Server, id string) *Socket {
-	var uid uuid.UUID
-
-	if id == "" {
-		uid = uuid.New()
-	} else {
-		uid = uuid.MustParse(id)
+// newSocket creates socket whose context carries values of parent, e.g.
+// context of handshake request, but is cancelled only when socket closes.
+func newSocket(server *Server, protocol int, parent context.Context) *Socket {
+	ctx, cancelFunc := context.WithCancel(valuesContext{parent})
+
+	return &Socket{
+		server:        server,
+		mtx:           &sync.Mutex{},
+		id:            uuid.New(),
+		protocol:      protocol,
+		IsConnected:   false,
+		outbox:        newOutbox(server.options.OutboxSize, server.options.OutboxPolicy, server.droppedPackets),
+		transportMtx:  &sync.Mutex{},
+		upgradingChan: make(chan struct{}),
+		ctx:           ctx,
+		ctxCancelFunc: cancelFunc,
+		ctxValues:     context.Background(),
+		ctxValuesMtx:  &sync.Mutex{},
+		closeOnce:     &sync.Once{},
+		dispatchMtx:   &sync.Mutex{},
+		dispatchSlots: make(chan struct{}, server.options.DispatchQueueSize),
+		heartbeat:     heartbeatState{index: -1},
 	}
+}
 
-	// search socket in memory
-	server.socketsMtx.Lock()
-	socket, isFound := server.sockets[uid]
-	if !isFound || socket == nil {
-		ctx, cancelFunc := context.WithCancel(context.Background())
-		socket = &Socket{
-			server:        server,
-			mtx:           &sync.Mutex{},
-			id:            uid,
-			IsConnected:   false,
-			inbox:         make(chan *packet),
-			outbox:        make(chan *packet, 4),
-			Transport:     TRANSPORT_POLLING,
-			ctx:           ctx,
-			ctxCancelFunc: cancelFunc,
-		}
+// start handling socket using its transport
+func (socket *Socket) start() {
+	socket.server.heartbeat.add(socket)
+	go socket.read()
+	go socket.flush()
+}
 
-		server.sockets[uid] = socket
+// onPacket handles packet received from client
+func (socket *Socket) onPacket(p *Packet) {
+	switch p.packetType {
+	case PACKET_MESSAGE:
+		socket.onMessage(string(p.data))
 
-		go socket.handle()
+	case PACKET_PAYLOAD:
+		socket.onMessage(p.data)
+
+	case PACKET_PONG:
+		socket.server.heartbeat.onPong(socket)
+
+	case PACKET_CLOSE:
+		socket.closeWithError(ErrTransportClose)
+
+	case PACKET_PING:
+		// in protocol v3 client sends ping and server waits it
+		if socket.protocol == PROTOCOL_V3 {
+			socket.server.heartbeat.onPing(socket)
+			socket.sendPacket(NewPacket(PACKET_PONG, p.data))
+		}
 	}
-	server.socketsMtx.Unlock()
+}
 
-	return socket
+// onMessage passes message to receive handler or dispatches message handler
+func (socket *Socket) onMessage(message interface{}) {
+	if socket.handlers.receive != nil {
+		socket.runSafely(func() {
+			socket.handlers.receive(socket, message)
+		})
+		return
+	}
+
+	socket.dispatch(func() {
+		if socket.handlers.message != nil {
+			socket.handlers.message(socket, message)
+		}
+	})
 }
 
-// handle socket message, ping, etc
-func (socket *Socket) handle() error {
-	var newPacket *packet
-	var pingTimeoutTimer *time.Timer
-	var err error = nil
+// Handle request connect by socket
+func (socket *Socket) connect() {
+	data := map[string]interface{}{
+		"sid":          socket.id.String(),
+		"upgrades":     socket.server.upgrades(socket.Transport()),
+		"pingInterval": socket.server.options.PingInterval,
+		"pingTimeout":  socket.server.options.PingTimeout,
+	}
+	if socket.protocol != PROTOCOL_V3 {
+		data["maxPayload"] = socket.server.options.MaxHTTPBufferSize
+	}
+	socket.IsConnected = true
+	jsonData, _ := json.Marshal(data)
+	socket.sendPacket(NewPacket(PACKET_OPEN, jsonData))
+
+	// packets are read after connection handler returns, so handlers added
+	// by it get every message
+	opened := make(chan struct{})
+	socket.dispatch(func() {
+		defer close(opened)
+		if socket.server.handlers.connection != nil {
+			socket.server.handlers.connection(socket)
+		}
+	})
+	select {
+	case <-opened:
+	case <-socket.ctx.Done():
+	}
+}
 
-	pingIntervalTimer := time.NewTimer(time.Duration(socket.server.options.PingInterval) * time.Millisecond)
+// read receives packets from transport
+func (socket *Socket) read() {
+	defer socket.recoverGoroutine()
 
 	if !socket.IsConnected {
 		socket.connect()
 	}
 
-	isChanOk := false
 	for {
-		newPacket = nil
-		if pingTimeoutTimer == nil { // if not pinging
-			select {
-			case <-socket.ctx.Done():
-				goto close
-
-			case newPacket, isChanOk = <-socket.inbox:
-				if isChanOk {
-					goto handleNewPacket
-				}
+		t := socket.Transport()
+		packets, err := t.Receive()
+		if err != nil {
+			if socket.ctx.Err() != nil {
+				return
+			}
+			// upgraded to new transport
+			if t != socket.Transport() {
+				continue
+			}
+			if err != ErrTransportError {
+				err = ErrTransportClose
+			}
+			socket.closeWithError(err)
+			return
+		}
 
-			case <-pingIntervalTimer.C:
-				pingIntervalTimer.Reset(time.Duration(socket.server.options.PingInterval) * time.Millisecond)
-				pingTimeoutTimer = time.NewTimer(time.Duration(socket.server.options.PingTimeout) * time.Millisecond)
-				if err = socket.sendPacket(NewPacket(PACKET_PING, []byte{})); err != nil {
-					goto close
-				}
+		for _, p := range packets {
+			if socket.ctx.Err() != nil {
+				return
 			}
+			socket.onPacket(p)
+		}
+	}
+}
+
+// flush sends packets of outbox using transport
+func (socket *Socket) flush() {
+	defer socket.recoverGoroutine()
 
-		} else { // if pinging
+	for {
+		first, isFound := socket.outbox.tryPop()
+		if !isFound {
 			select {
 			case <-socket.ctx.Done():
-				goto close
+				return
 
-			case newPacket, isChanOk = <-socket.inbox:
-				if isChanOk {
-					goto handleNewPacket
-				}
-
-			case <-pingTimeoutTimer.C:
-				err = ErrPingTimeout
-				goto close
+			case <-socket.outbox.notEmpty:
+				continue
 			}
 		}
 
-		continue
+		t := socket.Transport()
+		packets := []*Packet{first}
 
-	handleNewPacket:
-		if newPacket.packetType == PACKET_MESSAGE {
-			if socket.handlers.message != nil {
-				socket.handlers.message(socket, string(newPacket.data))
+		if !t.SupportsFraming() {
+			packets = socket.batch(packets)
+		}
+
+		for err := t.Send(packets); err != nil; err = t.Send(packets) {
+			if socket.ctx.Err() != nil {
+				return
 			}
-		} else if newPacket.packetType == PACKET_PAYLOAD {
-			if socket.handlers.message != nil {
-				socket.handlers.message(socket, newPacket.data)
+			// resend using upgraded transport
+			if current := socket.Transport(); current != t {
+				t = current
+				continue
 			}
-		} else if newPacket.packetType == PACKET_PONG {
-			pingTimeoutTimer = nil
+			socket.closeWithError(ErrTransportError)
+			return
 		}
-	}
 
-	// closing socket
-close:
-	socket.server.socketsMtx.Lock()
+		for _, p := range packets {
+			p.done(nil)
+		}
+	}
+}
 
-	socket.IsConnected = false
-	delete(socket.server.sockets, socket.id)
-	if socket.handlers.closed != nil {
-		socket.handlers.closed(socket)
+// batch appends packets which are already queued in outbox as long as
+// encoded payload does not exceed maxPayload. Packets sent with and without
+// compression are not batched together, so a response is compressed only if
+// all of its packets are compressible.
+func (socket *Socket) batch(packets []*Packet) []*Packet {
+	maxSize := socket.server.options.MaxHTTPBufferSize
+	size := -1
+	for _, p := range packets {
+		size += len(socket.encodePollingPacket(p)) + 1
 	}
 
-	socket.server.socketsMtx.Unlock()
-	return err
+	for {
+		p, isFound := socket.outbox.peek()
+		if !isFound || p.noCompress != packets[0].noCompress {
+			return packets
+		}
+
+		size += len(socket.encodePollingPacket(p)) + 1
+		if size > maxSize {
+			return packets
+		}
+
+		socket.outbox.tryPop()
+		packets = append(packets, p)
+	}
 }
 
-// Handle request connect by socket
-func (socket *Socket) connect() {
-	data := map[string]interface{}{
-		"sid":          socket.id.String(),
-		"upgrades":     []string{"websocket"},
-		"pingInterval": socket.server.options.PingInterval,
-		"pingTimeout":  socket.server.options.PingTimeout,
+// upgrade probes transport t and switches socket to it
+func (socket *Socket) upgrade(t Transport) {
+	isUpgraded := false
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		timer := time.NewTimer(UPGRADE_TIMEOUT)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+			return
+		case <-socket.ctx.Done():
+		case <-timer.C:
+		}
+		t.Close()
+	}()
+
+	isProbed := false
+	defer func() {
+		if isUpgraded {
+			return
+		}
+		t.Close()
+		if isProbed {
+			socket.transportMtx.Lock()
+			socket.isProbed = false
+			socket.upgradingChan = make(chan struct{})
+			socket.transportMtx.Unlock()
+		}
+	}()
+
+	for {
+		packets, err := t.Receive()
+		if err != nil {
+			return
+		}
+
+		for _, p := range packets {
+			switch {
+			case p.packetType == PACKET_PING && string(p.data) == "probe":
+				// transport is probed once, and one transport at a time
+				if isProbed {
+					return
+				}
+				upgrading := socket.probe()
+				if upgrading == nil {
+					return
+				}
+				isProbed = true
+
+				if err := t.Send([]*Packet{NewPacket(PACKET_PONG, []byte("probe"))}); err != nil {
+					return
+				}
+				close(upgrading)
+
+			case p.packetType == PACKET_UPGRADE:
+				socket.transportMtx.Lock()
+				old := socket.transport
+				socket.transport = t
+				socket.transportMtx.Unlock()
+
+				isUpgraded = true
+				old.Close()
+				return
+
+			default:
+				return
+			}
+		}
 	}
-	socket.IsConnected = true
-	jsonData, _ := json.Marshal(data)
-	socket.sendPacket(NewPacket(PACKET_OPEN, jsonData))
-	if socket.server.handlers.connection != nil {
-		socket.server.handlers.connection(socket)
+}
+
+// Transport returns current transport of socket
+func (socket *Socket) Transport() Transport {
+	socket.transportMtx.Lock()
+	defer socket.transportMtx.Unlock()
+	return socket.transport
+}
+
+// probe marks socket probed by new transport and returns channel to close
+// when probe is answered, nil if another transport is probing
+func (socket *Socket) probe() chan struct{} {
+	socket.transportMtx.Lock()
+	defer socket.transportMtx.Unlock()
+
+	if socket.isProbed {
+		return nil
 	}
+	socket.isProbed = true
+	return socket.upgradingChan
+}
+
+// upgrading returns channel closed when client probes new transport
+func (socket *Socket) upgrading() chan struct{} {
+	socket.transportMtx.Lock()
+	defer socket.transportMtx.Unlock()
+	return socket.upgradingChan
+}
+
+// Protocol returns Engine.IO protocol version of socket, 3 or 4
+func (socket *Socket) Protocol() int {
+	return socket.protocol
+}
+
+// Handshake returns details of HTTP request that created socket
+func (socket *Socket) Handshake() Handshake {
+	return socket.handshake
 }
 
 // Send to socket client
 func (socket *Socket) Send(message interface{}, timeout ...time.Duration) error {
-	var p *packet = &packet{}
+	p, err := newMessagePacket(message)
+	if err != nil {
+		return err
+	}
+
+	return socket.sendPacket(p, timeout...)
+}
+
+// SendWithOptions send to socket client with modified options
+func (socket *Socket) SendWithOptions(message interface{}, opt SendOptions, timeout ...time.Duration) error {
+	return socket.SendAllWithOptions([]interface{}{message}, opt, timeout...)
+}
+
+// SendAllWithOptions sends messages which must not be separated, e.g.
+// Socket.IO packet and its binary attachments. Messages are queued or dropped
+// together by outbox policy, and no other message is sent between them.
+// Timeout covers all messages, and WaitWritten and Written are of the last
+// one, as packets are written in order.
+func (socket *Socket) SendAllWithOptions(messages []interface{}, opt SendOptions, timeout ...time.Duration) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	packets := make([]*Packet, 0, len(messages))
+	for _, message := range messages {
+		p, err := newMessagePacket(message)
+		if err != nil {
+			return err
+		}
+		p.noCompress = opt.NoCompress
+		packets = append(packets, p)
+	}
+
+	if opt.Volatile {
+		return socket.sendVolatile(packets...)
+	}
+
+	last := packets[len(packets)-1]
+	if !opt.WaitWritten {
+		last.callback = opt.Written
+		return socket.sendPackets(packets, timeout...)
+	}
+
+	// timeout covers queueing and writing
+	var timer <-chan time.Time
+	if len(timeout) > 0 && timeout[0] > 0 {
+		t := time.NewTimer(timeout[0])
+		defer t.Stop()
+		timer = t.C
+	}
+
+	last.callback = make(chan error, 1)
+	if err := socket.sendPackets(packets, timeout...); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-last.callback:
+		return err
+	case <-socket.ctx.Done():
+		return ErrSocketClosed
+	case <-timer:
+		return ErrTimeout
+	}
+}
+
+// sendVolatile queues packets only if transport is writable and outbox has
+// room for all of them
+func (socket *Socket) sendVolatile(packets ...*Packet) error {
+	if !socket.Transport().Writable() || !socket.outbox.tryPush(packets...) {
+		return ErrPacketDropped
+	}
+	return nil
+}
+
+func newMessagePacket(message interface{}) (*Packet, error) {
+	var p *Packet = &Packet{}
 
 	switch data := message.(type) {
 	case string:
@@ -173,35 +456,54 @@ func (socket *Socket) Send(message interface{}, timeout ...time.Duration) error
 		p.data = data
 
 	default:
-		return ErrMessageNotSupported
+		return nil, ErrMessageNotSupported
 	}
 
-	return socket.sendPacket(p, timeout...)
+	return p, nil
 }
 
-func (socket *Socket) sendPacket(p *packet, timeout ...time.Duration) error {
-	socket.mtx.Lock()
-	defer socket.mtx.Unlock()
+// sendPacket queues packet to outbox. Optional timeout limits how long it
+// blocks when outbox is full.
+func (socket *Socket) sendPacket(p *Packet, timeout ...time.Duration) error {
+	return socket.sendPackets([]*Packet{p}, timeout...)
+}
 
-	if socket.outbox == nil {
-		return ErrSocketClosed
+// sendPackets queues packets which must not be separated to outbox
+func (socket *Socket) sendPackets(packets []*Packet, timeout ...time.Duration) error {
+	var t time.Duration
+	if len(timeout) > 0 {
+		t = timeout[0]
 	}
 
-	select {
-	case socket.outbox <- p:
-		return nil
-
-	case <-socket.ctx.Done():
+	err := socket.outbox.pushAll(packets, socket.ctx.Done(), t)
+	if err == ErrOutboxFull {
+		socket.closeWithError(ErrOutboxFull)
 		return ErrSocketClosed
 	}
+	return err
+}
+
+// DroppedPackets returns number of packets dropped by outbox policy
+func (socket *Socket) DroppedPackets() uint64 {
+	return socket.outbox.droppedCount()
+}
+
+// Context returns context of socket which is cancelled when socket closes. It
+// carries values of handshake request context.
+func (socket *Socket) Context() context.Context {
+	return socket.ctx
 }
 
 func (socket *Socket) SetCtxValue(key ContextKey, value interface{}) {
-	socket.ctx = context.WithValue(socket.ctx, key, value)
+	socket.ctxValuesMtx.Lock()
+	defer socket.ctxValuesMtx.Unlock()
+	socket.ctxValues = context.WithValue(socket.ctxValues, key, value)
 }
 
 func (socket *Socket) GetCtxValue(key ContextKey) (value interface{}) {
-	value = socket.ctx.Value(key)
+	socket.ctxValuesMtx.Lock()
+	defer socket.ctxValuesMtx.Unlock()
+	value = socket.ctxValues.Value(key)
 	return
 }
 
@@ -210,16 +512,74 @@ func (socket *Socket) OnMessage(f func(*Socket, interface{})) {
 	socket.handlers.message = f
 }
 
+// OnReceive add handler on incoming new message, called by reading goroutine
+// instead of dispatching handler of OnMessage. It must be added by connection
+// handler and must not block, other work is queued by Dispatch.
+func (socket *Socket) OnReceive(f func(*Socket, interface{})) {
+	socket.handlers.receive = f
+}
+
+// Dispatch queues f to run in order with other handlers of socket. It waits
+// while queue of socket is full.
+func (socket *Socket) Dispatch(f func()) {
+	socket.dispatch(f)
+}
+
 // OnMessage add handler on incoming new message. Second argument can be string or bytes
 func (socket *Socket) OnClosed(f func(*Socket)) {
 	socket.handlers.closed = f
 }
 
-func (socket *Socket) close() {
-	socket.ctxCancelFunc()
+// CloseReason returns error that caused socket closed, nil if closed normally
+func (socket *Socket) CloseReason() error {
+	socket.mtx.Lock()
+	defer socket.mtx.Unlock()
+	return socket.closeReason
+}
 
+func (socket *Socket) closeWithError(err error) {
 	socket.mtx.Lock()
-	close(socket.outbox)
-	socket.outbox = nil
+	if socket.closeReason == nil {
+		socket.closeReason = err
+	}
 	socket.mtx.Unlock()
+	socket.close()
+}
+
+// valuesContext carries values of its parent but never cancelled by it
+type valuesContext struct {
+	parent context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valuesContext) Done() <-chan struct{}                   { return nil }
+func (valuesContext) Err() error                              { return nil }
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func (socket *Socket) close() {
+	socket.closeOnce.Do(func() {
+		socket.ctxCancelFunc()
+		socket.server.heartbeat.remove(socket)
+
+		if t := socket.Transport(); t != nil {
+			t.Close()
+		}
+
+		socket.outbox.close()
+
+		socket.server.socketsMtx.Lock()
+		socket.IsConnected = false
+		delete(socket.server.sockets, socket.id)
+		socket.server.socketsMtx.Unlock()
+
+		// run after handlers of received messages
+		socket.dispatch(func() {
+			if socket.handlers.closed != nil {
+				socket.handlers.closed(socket)
+			}
+		})
+	})
 }
@@ -0,0 +1,143 @@
+package engineio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var errTransportClosed = errors.New("transport closed")
+
+// ctxKeySocket is key of socket in context of request served by transport
+const ctxKeySocket ContextKey = 0x12
+
+// TransportType is type of transport.
+//
+// Deprecated: transports are identified by name, see Transport.Name.
+type TransportType byte
+
+const (
+	// Deprecated: use transport name "polling"
+	TRANSPORT_POLLING TransportType = 0
+	// Deprecated: use transport name "websocket"
+	TRANSPORT_WEBSOCKET TransportType = 1
+)
+
+// String returns name of transport type, e.g. "polling"
+func (t TransportType) String() string {
+	switch t {
+	case TRANSPORT_POLLING:
+		return "polling"
+	case TRANSPORT_WEBSOCKET:
+		return "websocket"
+	}
+	return ""
+}
+
+// Transport is a way to transfer packets between a socket and its client.
+// A transport is created for a socket by TransportFactory registered in
+// Server and is used by one socket only. Transport with framing sends a
+// packet per frame encoded by Packet.Encode, or Packet.Data for binary
+// packet, and decodes text frames by DecodePacket. Transport without framing
+// uses Socket.EncodePayload and Socket.DecodePayload.
+type Transport interface {
+	// Name is value of transport query parameter, e.g. "polling"
+	Name() string
+
+	// SupportsFraming reports whether packets are sent one by one. Transport
+	// without framing gets packets as a batch limited by maxPayload and can
+	// be upgraded to transport with framing.
+	SupportsFraming() bool
+
+	// HandleRequest serves http request of the transport. It must not block
+	// for lifetime of a persistent connection.
+	HandleRequest(w http.ResponseWriter, req *http.Request)
+
+	// Send writes packets to client. It blocks until packets are written.
+	Send(packets []*Packet) error
+
+	// Writable reports whether Send would write packets immediately
+	Writable() bool
+
+	// Receive blocks until packets from client are received
+	Receive() ([]*Packet, error)
+
+	// Close closes transport, blocked Send and Receive return error
+	Close() error
+}
+
+// TransportFactory creates transport for socket
+type TransportFactory func(socket *Socket) Transport
+
+// RegisterTransport add or replace transport of name
+func (server *Server) RegisterTransport(name string, factory TransportFactory) {
+	server.transportsMtx.Lock()
+	defer server.transportsMtx.Unlock()
+
+	if _, isFound := server.transports[name]; !isFound {
+		server.transportNames = append(server.transportNames, name)
+	}
+	server.transports[name] = factory
+}
+
+func (server *Server) transportFactory(name string) (factory TransportFactory, isFound bool) {
+	server.transportsMtx.Lock()
+	defer server.transportsMtx.Unlock()
+
+	factory, isFound = server.transports[name]
+	return
+}
+
+// upgrades returns transports that socket using transport current can upgrade to
+func (server *Server) upgrades(current Transport) []string {
+	upgrades := []string{}
+	if current.SupportsFraming() {
+		return upgrades
+	}
+
+	server.transportsMtx.Lock()
+	defer server.transportsMtx.Unlock()
+
+	for _, name := range server.transportNames {
+		if name != current.Name() {
+			upgrades = append(upgrades, name)
+		}
+	}
+	return upgrades
+}
+
+// withSocket returns req whose context carries socket
+func withSocket(req *http.Request, socket *Socket) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), ctxKeySocket, socket))
+}
+
+// ServePolling serves polling request of socket carried by request context.
+// Request without socket gets CLOSE packet.
+//
+// Deprecated: Server.ServeHTTP serves requests of all transports.
+func ServePolling(w http.ResponseWriter, req *http.Request) {
+	serveTransportType(w, req, TRANSPORT_POLLING)
+}
+
+// TransportWebsocketHandler serves websocket requests by ServeWebsocket.
+//
+// Deprecated: Server.ServeHTTP serves requests of all transports.
+var TransportWebsocketHandler http.Handler = http.HandlerFunc(ServeWebsocket)
+
+// ServeWebsocket serves websocket request of socket carried by request
+// context, upgrading socket to websocket. Request without socket gets CLOSE
+// packet.
+//
+// Deprecated: Server.ServeHTTP serves requests of all transports.
+func ServeWebsocket(w http.ResponseWriter, req *http.Request) {
+	serveTransportType(w, req, TRANSPORT_WEBSOCKET)
+}
+
+func serveTransportType(w http.ResponseWriter, req *http.Request, t TransportType) {
+	socket, isFound := req.Context().Value(ctxKeySocket).(*Socket)
+	if !isFound {
+		w.Write([]byte(NewPacket(PACKET_CLOSE, []byte{}).encode()))
+		return
+	}
+	socket.server.serveTransport(w, req, socket, t.String())
+}
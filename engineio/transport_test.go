@@ -0,0 +1,293 @@
+package engineio
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebsocket opens websocket of protocol v4 with query appended
+func dialWebsocket(t *testing.T, ts *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/engine.io/?EIO=4&transport=websocket" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readText reads text message of websocket
+func readText(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func TestUpgrade(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{})
+
+	received := make(chan interface{}, 1)
+	server.OnConnection(func(socket *Socket) {
+		socket.OnMessage(func(socket *Socket, message interface{}) {
+			received <- message
+		})
+	})
+
+	sid, open := pollingHandshake(t, ts)
+	if !reflect.DeepEqual(open["upgrades"], []interface{}{"websocket"}) {
+		t.Errorf("upgrades = %v, want [websocket]", open["upgrades"])
+	}
+	socket := server.getSocket(sid)
+
+	// waiting GET is released by NOOP when client probes websocket
+	polled := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/engine.io/?EIO=4&transport=polling&sid=" + sid)
+		if err != nil {
+			polled <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		polled <- string(b)
+	}()
+
+	conn := dialWebsocket(t, ts, "&sid="+sid)
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if msg := readText(t, conn); msg != "3probe" {
+		t.Fatalf("probe response is %q, want 3probe", msg)
+	}
+
+	select {
+	case body := <-polled:
+		if body != "6" {
+			t.Errorf("waiting GET gets %q, want NOOP", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting GET is not released")
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("5"))
+	for i := 0; socket.Transport().Name() != "websocket"; i++ {
+		if i == 100 {
+			t.Fatal("socket is not upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	socket.Send("hello")
+	if msg := readText(t, conn); msg != "4hello" {
+		t.Errorf("websocket receives %q, want 4hello", msg)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("4hi"))
+	select {
+	case message := <-received:
+		if message != "hi" {
+			t.Errorf("socket receives %v, want hi", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message sent by websocket is not received")
+	}
+}
+
+func TestUpgradeProbedTwice(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{})
+
+	sid, _ := pollingHandshake(t, ts)
+	socket := server.getSocket(sid)
+
+	// repeated probe closes the probing transport
+	conn := dialWebsocket(t, ts, "&sid="+sid)
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if msg := readText(t, conn); msg != "3probe" {
+		t.Fatalf("probe response is %q, want 3probe", msg)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, msg, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("websocket receives %q after repeated probe, want close", msg)
+	}
+
+	// polling still works and client can upgrade again
+	resp := pollingRequest(t, ts, http.MethodPost, "&sid="+sid, "4hello")
+	if body := readBody(t, resp); body != "ok" {
+		t.Errorf("POST response is %q, want ok", body)
+	}
+
+	conn = dialWebsocket(t, ts, "&sid="+sid)
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if msg := readText(t, conn); msg != "3probe" {
+		t.Fatalf("probe response is %q, want 3probe", msg)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("5"))
+	for i := 0; socket.Transport().Name() != "websocket"; i++ {
+		if i == 100 {
+			t.Fatal("socket is not upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{CORS: &CORSOptions{Origins: []string{"https://example.com"}}})
+
+	// socket using websocket can not be polled
+	conn := dialWebsocket(t, ts, "")
+	open := readText(t, conn)
+	websocketSid := struct{ Sid string }{}
+	json.Unmarshal([]byte(open[1:]), &websocketSid)
+	if server.getSocket(websocketSid.Sid) == nil {
+		t.Fatalf("websocket session is not found, OPEN is %q", open)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		origin     string
+		wantStatus int
+		wantCode   int
+	}{
+		{"Unknown transport", http.MethodGet, "?EIO=4&transport=carrier-pigeon", "", http.StatusBadRequest, ERROR_UNKNOWN_TRANSPORT},
+		{"Unknown sid", http.MethodGet, "?EIO=4&transport=polling&sid=unknown", "", http.StatusBadRequest, ERROR_UNKNOWN_SID},
+		{"Bad handshake method", http.MethodPost, "?EIO=4&transport=polling", "", http.StatusBadRequest, ERROR_BAD_HANDSHAKE_METHOD},
+		{"Downgrade", http.MethodGet, "?EIO=4&transport=polling&sid=" + websocketSid.Sid, "", http.StatusBadRequest, ERROR_BAD_REQUEST},
+		{"Forbidden origin", http.MethodGet, "?EIO=4&transport=polling", "https://evil.com", http.StatusForbidden, ERROR_FORBIDDEN},
+		{"Unsupported version", http.MethodGet, "?EIO=3&transport=polling", "", http.StatusBadRequest, ERROR_UNSUPPORTED_PROTOCOL_VERSION},
+		{"No version", http.MethodGet, "?transport=polling", "", http.StatusBadRequest, ERROR_UNSUPPORTED_PROTOCOL_VERSION},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, ts.URL+"/engine.io/"+tt.query, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := readBody(t, resp)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+
+			got := struct {
+				Code    *int
+				Message string
+			}{}
+			if err := json.Unmarshal([]byte(body), &got); err != nil || got.Code == nil {
+				t.Fatalf("response is %q, want JSON error", body)
+			}
+			if *got.Code != tt.wantCode || got.Message != errorMessages[tt.wantCode] {
+				t.Errorf("error is %d %q, want %d %q", *got.Code, got.Message, tt.wantCode, errorMessages[tt.wantCode])
+			}
+		})
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *Packet
+	}{
+		{"4hello", &Packet{packetType: PACKET_MESSAGE, data: []byte("hello")}},
+		{"2probe", &Packet{packetType: PACKET_PING, data: []byte("probe")}},
+		{"bAQID", &Packet{packetType: PACKET_PAYLOAD, data: []byte{1, 2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := DecodePacket(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodePacket() = %v, want %v", got, tt.want)
+			}
+			if encoded := got.Encode(); encoded != tt.s {
+				t.Errorf("Encode() = %q, want %q", encoded, tt.s)
+			}
+		})
+	}
+}
+
+func TestPayload(t *testing.T) {
+	server := NewServer(EngineIOOptions{})
+	packets := []*Packet{
+		NewPacket(PACKET_MESSAGE, []byte("hello")),
+		NewPacket(PACKET_PAYLOAD, []byte{1, 2, 3}),
+	}
+
+	tests := []struct {
+		protocol int
+		want     string
+	}{
+		{PROTOCOL_V4, "4hello\x1ebAQID"},
+		{PROTOCOL_V3, "6:4hello6:b4AQID"},
+	}
+
+	for _, tt := range tests {
+		socket := newSocket(server, tt.protocol, context.Background())
+
+		payload := socket.EncodePayload(packets)
+		if string(payload) != tt.want {
+			t.Errorf("v%d EncodePayload() = %q, want %q", tt.protocol, payload, tt.want)
+		}
+
+		decoded, err := socket.DecodePayload(payload, "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, packets) {
+			t.Errorf("v%d DecodePayload() = %v, want %v", tt.protocol, decoded, packets)
+		}
+	}
+}
+
+func TestDeprecatedServePolling(t *testing.T) {
+	if TRANSPORT_POLLING.String() != "polling" || TRANSPORT_WEBSOCKET.String() != "websocket" {
+		t.Errorf("transport type names are %q and %q", TRANSPORT_POLLING, TRANSPORT_WEBSOCKET)
+	}
+
+	// request without socket is closed
+	w := httptest.NewRecorder()
+	ServePolling(w, httptest.NewRequest(http.MethodGet, "/engine.io/?EIO=4&transport=polling", nil))
+	if body := w.Body.String(); body != "1" {
+		t.Errorf("ServePolling() writes %q, want CLOSE packet", body)
+	}
+
+	server := NewServer(EngineIOOptions{})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+	socket.transport = newPollingTransport(socket)
+
+	req := httptest.NewRequest(http.MethodPost, "/engine.io/?EIO=4&transport=polling", strings.NewReader("6"))
+	req.Header.Set("Content-Type", "text/plain")
+	go socket.transport.Receive()
+	w = httptest.NewRecorder()
+	ServePolling(w, withSocket(req, socket))
+	if body := w.Body.String(); body != "ok" {
+		t.Errorf("ServePolling() writes %q, want ok", body)
+	}
+}
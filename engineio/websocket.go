@@ -1,143 +1,195 @@
 package engineio
 
 import (
-	"golang.org/x/net/websocket"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
 )
 
-type websocketMessage struct {
-	payloadType byte
-	message     []byte
+// websocketTransport uses github.com/gorilla/websocket instead of
+// golang.org/x/net/websocket, which does not support permessage-deflate
+// extension needed by EngineIOOptions.PerMessageDeflate.
+type websocketTransport struct {
+	socket   *Socket
+	mtx      *sync.Mutex
+	writeMtx *sync.Mutex
+	conn     *websocket.Conn
+	isClosed bool
+	writing  *int32 // 1 while writing packets
+
+	// ready is closed when connection is upgraded or failed to
+	ready     chan struct{}
+	readyOnce *sync.Once
+}
+
+func newWebsocketTransport(socket *Socket) Transport {
+	return &websocketTransport{
+		socket:    socket,
+		mtx:       &sync.Mutex{},
+		writeMtx:  &sync.Mutex{},
+		writing:   new(int32),
+		ready:     make(chan struct{}),
+		readyOnce: &sync.Once{},
+	}
 }
 
-var TransportWebsocket = struct {
-	codec websocket.Codec
-}{
-	codec: websocket.Codec{Marshal: wsMarshal, Unmarshal: wsUnmarshal},
+func newWebsocketUpgrader(options EngineIOOptions) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		EnableCompression: options.PerMessageDeflate != nil,
+		CheckOrigin: func(req *http.Request) bool {
+			origin := req.Header.Get("Origin")
+			return options.CORS == nil || origin == "" || options.CORS.isOriginAllowed(origin)
+		},
+	}
 }
 
-var TransportWebsocketHandler = websocket.Handler(ServeWebsocket)
+func (t *websocketTransport) Name() string {
+	return "websocket"
+}
 
-func wsMarshal(v interface{}) (msg []byte, payloadType byte, err error) {
-	switch data := v.(type) {
-	case string:
-		return []byte(data), websocket.TextFrame, nil
-	case []byte:
-		return data, websocket.BinaryFrame, nil
+func (t *websocketTransport) SupportsFraming() bool {
+	return true
+}
+
+// Handle transport websocket
+func (t *websocketTransport) HandleRequest(w http.ResponseWriter, req *http.Request) {
+	defer t.readyOnce.Do(func() { close(t.ready) })
+
+	options := t.socket.server.options
+
+	// w is hijacked, so headers set by handlers must be passed to upgrader
+	conn, err := newWebsocketUpgrader(options).Upgrade(w, req, w.Header())
+	if err != nil {
+		return
 	}
-	return nil, websocket.UnknownFrame, websocket.ErrNotSupported
+
+	conn.SetReadLimit(int64(options.MaxHTTPBufferSize))
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.isClosed {
+		conn.Close()
+		return
+	}
+	t.conn = conn
 }
 
-func wsUnmarshal(msg []byte, payloadType byte, v interface{}) (err error) {
-	data, isOK := v.(*websocketMessage)
+// getConn waits connection upgraded, nil if failed or transport closed
+func (t *websocketTransport) getConn() *websocket.Conn {
+	<-t.ready
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.conn
+}
 
-	if !isOK {
-		return websocket.ErrNotSupported
+func (t *websocketTransport) Send(packets []*Packet) error {
+	conn := t.getConn()
+	if conn == nil {
+		return errTransportClosed
 	}
 
-	data.payloadType = payloadType
-	data.message = msg
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
 
+	atomic.StoreInt32(t.writing, 1)
+	defer atomic.StoreInt32(t.writing, 0)
+
+	for _, p := range packets {
+		if err := t.send(conn, p); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func ServeWebsocket(conn *websocket.Conn) {
-	socket := conn.Request().Context().Value(ctxKeySocket).(*Socket)
-	message := websocketMessage{}
-	closeChan := make(chan error)
+// Writable reports whether connection is upgraded and not busy writing
+func (t *websocketTransport) Writable() bool {
+	t.mtx.Lock()
+	isReady := t.conn != nil && !t.isClosed
+	t.mtx.Unlock()
 
-	defer func() {
-		socket.close()
-		conn.Close()
-	}()
+	return isReady && atomic.LoadInt32(t.writing) == 0
+}
 
-	// handshacking for change transport
-	for isHandshackingFinished := false; !isHandshackingFinished; {
-		err := TransportWebsocket.codec.Receive(conn, &message)
-		if err != nil {
-			return
-		}
+func (t *websocketTransport) send(conn *websocket.Conn, p *Packet) error {
+	var payloadType int
+	var msg []byte
 
-		switch string(message.message) {
-		case "2probe":
-			if err := TransportWebsocket.codec.Send(conn, "3probe"); err != nil {
-				return
-			}
-			socket.Transport = TRANSPORT_WEBSOCKET
-			if socket.isPollingWaiting {
-				socket.mtx.Lock()
-				if socket.outbox != nil {
-					socket.outbox <- NewPacket(PACKET_NOOP, []byte{})
-				}
-				socket.mtx.Unlock()
-			}
+	options := t.socket.server.options
 
-		case string(PACKET_UPGRADE):
-			isHandshackingFinished = true
+	if p.packetType == PACKET_PAYLOAD {
+		payloadType = websocket.BinaryMessage
+		msg = p.data
 
-		default:
-			return
+		// in protocol v3 binary message is prefixed by packet type
+		if t.socket.protocol == PROTOCOL_V3 {
+			msg = append([]byte{byte(PACKET_MESSAGE - '0')}, p.data...)
 		}
+	} else {
+		payloadType = websocket.TextMessage
+		msg = []byte(p.encode())
 	}
 
-	go func(*websocket.Conn, *Socket) {
-		var p *packet
-		message := websocketMessage{}
-
-		// listener: packet reciever
-		for socket.IsConnected {
-			p = nil
-			if err := TransportWebsocket.codec.Receive(conn, &message); err != nil {
-				closeChan <- err
-				break
-			}
+	if deflate := options.PerMessageDeflate; deflate != nil {
+		conn.EnableWriteCompression(!p.noCompress && len(msg) >= deflate.Threshold)
+	}
 
-			if len(message.message) == 0 {
-				continue
-			}
+	return conn.WriteMessage(payloadType, msg)
+}
 
-			// handle incomming packet
-			if message.payloadType == 0x01 { // string message
-				p = &packet{
-					packetType: eioPacketType(message.message[0]),
-					data:       message.message[1:],
-				}
-			} else if message.payloadType == 0x02 { // binary message
-				p = &packet{
-					packetType: PACKET_PAYLOAD,
-					data:       message.message,
-				}
-			}
+func (t *websocketTransport) Receive() ([]*Packet, error) {
+	conn := t.getConn()
+	if conn == nil {
+		return nil, errTransportClosed
+	}
 
-			select {
-			case <-socket.ctx.Done():
-				continue
+	for {
+		payloadType, message, err := conn.ReadMessage()
+		if err == websocket.ErrReadLimit {
+			return nil, ErrTransportError
+		}
+		if err != nil {
+			return nil, err
+		}
 
-			case socket.inbox <- p:
-				continue
-			}
+		if len(message) == 0 {
+			continue
 		}
-	}(conn, socket)
-
-	// listener: packet sender
-	for socket.IsConnected {
-		select {
-		case p := <-socket.outbox:
-			if p.packetType == PACKET_PAYLOAD {
-				if err := TransportWebsocket.codec.Send(conn, p.data); err != nil {
-					return
-				}
-			} else {
-				if err := TransportWebsocket.codec.Send(conn, p.encode()); err != nil {
-					return
-				}
-			}
 
-			if p.callback != nil {
-				p.callback <- true
+		// handle incomming packet
+		if payloadType == websocket.TextMessage { // string message
+			return []*Packet{{
+				packetType: PacketType(message[0]),
+				data:       message[1:],
+			}}, nil
+
+		} else if payloadType == websocket.BinaryMessage { // binary message
+			p := &Packet{
+				packetType: PACKET_PAYLOAD,
+				data:       message,
 			}
 
-		case <-closeChan:
-			return
+			// in protocol v3 binary message is prefixed by packet type
+			if t.socket.protocol == PROTOCOL_V3 {
+				p.data = message[1:]
+			}
+			return []*Packet{p}, nil
 		}
 	}
 }
+
+func (t *websocketTransport) Close() error {
+	t.mtx.Lock()
+	t.isClosed = true
+	conn := t.conn
+	t.mtx.Unlock()
+
+	t.readyOnce.Do(func() { close(t.ready) })
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
@@ -0,0 +1,159 @@
+package engineio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// rawWebsocket is websocket client reading frames as they are sent, e.g. to
+// check compression bit
+type rawWebsocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRawWebsocket opens websocket session of protocol v4 offering
+// permessage-deflate
+func dialRawWebsocket(t *testing.T, ts *httptest.Server) *rawWebsocket {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/engine.io/?EIO=4&transport=websocket", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &rawWebsocket{conn: conn, r: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(ws.r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return ws
+}
+
+// readFrame reads unmasked frame sent by server and returns whether its
+// payload is compressed
+func (ws *rawWebsocket) readFrame(t *testing.T) (isCompressed bool, payload []byte) {
+	t.Helper()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.r, header); err != nil {
+		t.Fatal(err)
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		b := make([]byte, 2)
+		io.ReadFull(ws.r, b)
+		length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		io.ReadFull(ws.r, b)
+		length = binary.BigEndian.Uint64(b)
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0]&0x40 != 0, payload
+}
+
+// onlySocket returns the only socket of server
+func onlySocket(t *testing.T, server *Server) *Socket {
+	t.Helper()
+
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+	if len(server.sockets) != 1 {
+		t.Fatalf("server has %d sockets, want 1", len(server.sockets))
+	}
+	for _, socket := range server.sockets {
+		return socket
+	}
+	return nil
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	server, ts := newTestServer(t, EngineIOOptions{PerMessageDeflate: &CompressionOptions{Threshold: 64}})
+
+	ws := dialRawWebsocket(t, ts)
+	if _, open := ws.readFrame(t); len(open) == 0 {
+		t.Fatal("OPEN packet is not received")
+	}
+
+	socket := onlySocket(t, server)
+	long := strings.Repeat("a", 100)
+	socket.SendWithOptions(long, SendOptions{})
+	socket.SendWithOptions(long, SendOptions{NoCompress: true})
+	socket.Send("short")
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Compressible", true},
+		{"NoCompress", false},
+		{"Below threshold", false},
+	}
+	for _, tt := range tests {
+		if isCompressed, _ := ws.readFrame(t); isCompressed != tt.want {
+			t.Errorf("%s: compressed = %v, want %v", tt.name, isCompressed, tt.want)
+		}
+	}
+}
+
+func TestWebsocketWritable(t *testing.T) {
+	server := NewServer(EngineIOOptions{})
+	socket := newSocket(server, PROTOCOL_V4, context.Background())
+	transport := newWebsocketTransport(socket)
+
+	if transport.Writable() {
+		t.Error("Writable() = true before connection is upgraded")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(transport.HandleRequest))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// connection is set after handshake response
+	for i := 0; !transport.Writable(); i++ {
+		if i == 100 {
+			t.Fatal("Writable() = false after connection is upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	transport.Close()
+	if transport.Writable() {
+		t.Error("Writable() = true after transport is closed")
+	}
+}
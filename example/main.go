@@ -5,12 +5,9 @@ import (
 	"net/http"
 
 	"github.com/ghuvrons/siosver"
+	"github.com/ghuvrons/siosver/engineio"
 )
 
-type socketIOServer struct {
-	sioServer *siosver.Server
-}
-
 func main() {
 	server := &http.Server{
 		Addr:    ":8000",
@@ -21,7 +18,13 @@ func main() {
 }
 
 func socketIOInit() http.Handler {
-	io := new(siosver.Server)
+	io := siosver.NewServer(siosver.ServerOptions{
+		PingInterval: 25000,
+		PingTimeout:  20000,
+		CORS: &engineio.CORSOptions{
+			Origins: []string{"*"},
+		},
+	})
 	// sioHandler.Authenticator(func(data interface{}) bool {
 	// 	fmt.Println("auth data", data)
 	// 	return true
@@ -44,13 +47,5 @@ func socketIOInit() http.Handler {
 		// socket.Emit("test_bin", cbdata)
 	})
 
-	h := socketIOServer{}
-	h.sioServer = io
-
-	return h
-}
-
-func (svr socketIOServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	svr.sioServer.ServeHTTP(w, req)
+	return io
 }
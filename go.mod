@@ -4,5 +4,6 @@ go 1.16
 
 require (
 	github.com/google/uuid v1.3.0
-	golang.org/x/net v0.0.0-20211101193420-4a448f8816b3
+	github.com/gorilla/websocket v1.5.3
+	github.com/mattn/go-sqlite3 v1.14.16
 )
@@ -0,0 +1,98 @@
+package siosver
+
+import "time"
+
+// roomHistory is ring buffer of events emitted to a room
+type roomHistory struct {
+	events []historyEvent
+	start  int
+	count  int
+	maxAge time.Duration
+}
+
+type historyEvent struct {
+	args []interface{}
+	time time.Time
+}
+
+func newRoomHistory(size int, maxAge time.Duration) *roomHistory {
+	return &roomHistory{
+		events: make([]historyEvent, size),
+		maxAge: maxAge,
+	}
+}
+
+func (h *roomHistory) record(args []interface{}) {
+	event := historyEvent{args: args, time: time.Now()}
+	if h.count < len(h.events) {
+		h.events[(h.start+h.count)%len(h.events)] = event
+		h.count++
+		return
+	}
+	h.events[h.start] = event
+	h.start = (h.start + 1) % len(h.events)
+}
+
+// last returns at most n latest events not older than maxAge, oldest first
+func (h *roomHistory) last(n int) [][]interface{} {
+	if n > h.count {
+		n = h.count
+	}
+
+	var expiry time.Time
+	if h.maxAge > 0 {
+		expiry = time.Now().Add(-h.maxAge)
+	}
+
+	events := [][]interface{}{}
+	for i := h.count - n; i < h.count; i++ {
+		event := h.events[(h.start+i)%len(h.events)]
+		if event.time.Before(expiry) {
+			continue
+		}
+		events = append(events, event.args)
+	}
+	return events
+}
+
+// EnableHistory records last size events emitted to room, so sockets joining
+// by Socket.JoinWithHistory receive them. Events older than maxAge are not
+// replayed, zero means no limit. History is kept while room is empty.
+func (server *Server) EnableHistory(roomName string, size int, maxAge time.Duration) {
+	server.historyMtx.Lock()
+	defer server.historyMtx.Unlock()
+
+	if size <= 0 {
+		delete(server.histories, roomName)
+		return
+	}
+	server.histories[roomName] = newRoomHistory(size, maxAge)
+}
+
+// DisableHistory stops recording events of room and drops its history
+func (server *Server) DisableHistory(roomName string) {
+	server.EnableHistory(roomName, 0, 0)
+}
+
+// JoinWithHistory joins socket to room and emits last n recorded events of
+// the room to it before events emitted after joining.
+func (socket *Socket) JoinWithHistory(roomName string, n int) {
+	// events emitted after joining wait replayed ones being queued
+	socket.deliverMtx.Lock()
+	defer socket.deliverMtx.Unlock()
+
+	server := socket.server
+	server.historyMtx.Lock()
+	socket.SocketJoin(roomName)
+	var events [][]interface{}
+	if history, isFound := server.histories[roomName]; isFound {
+		events = history.last(n)
+	}
+	server.historyMtx.Unlock()
+
+	for _, args := range events {
+		if socket.sendPacket(newPacket(__SIO_PACKET_EVENT, args...), emitFlags{}) != nil {
+			return
+		}
+	}
+}
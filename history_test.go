@@ -0,0 +1,95 @@
+package siosver
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoomHistory(t *testing.T) {
+	h := newRoomHistory(3, 0)
+	for i := 0; i < 5; i++ {
+		h.record([]interface{}{"event", i})
+	}
+
+	got := h.last(2)
+	want := [][]interface{}{{"event", 3}, {"event", 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("last 2 events are %v, want %v", got, want)
+	}
+	if got := h.last(10); len(got) != 3 || got[0][1] != 2 {
+		t.Errorf("last 10 events are %v", got)
+	}
+
+	h = newRoomHistory(3, time.Minute)
+	h.record([]interface{}{"old"})
+	h.events[0].time = time.Now().Add(-time.Hour)
+	h.record([]interface{}{"new"})
+	if got := h.last(3); len(got) != 1 || got[0][0] != "new" {
+		t.Errorf("events not older than max age are %v", got)
+	}
+}
+
+func TestJoinWithHistory(t *testing.T) {
+	server := NewServer(ServerOptions{PingInterval: 25000, PingTimeout: 20000})
+	server.EnableHistory("room", 5, 0)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// buffer is sent to member and kept in history
+	member := newTestClient(t, server, ts)
+	member.Socket.SocketJoin("room")
+	server.To("room").Emit("file", bytes.NewBufferString("abc"))
+	member.expect(`51-["file",{"_placeholder":true,"num":0}]`)
+	if attachment := member.next(); attachment != "bYWJj" {
+		t.Errorf("attachment is %q, want bYWJj", attachment)
+	}
+
+	// replayed events come before events emitted after joining
+	c := newTestClient(t, server, ts)
+	c.Socket.JoinWithHistory("room", 10)
+	server.To("room").Emit("live")
+	c.expect(`51-["file",{"_placeholder":true,"num":0}]`)
+	if attachment := c.next(); attachment != "bYWJj" {
+		t.Errorf("attachment is %q, want bYWJj", attachment)
+	}
+	c.expect(`2["live"]`)
+}
+
+func TestHistoryNotBlocked(t *testing.T) {
+	server, c := newTestServerClient(t, ServerOptions{OutboxSize: 1})
+	server.EnableHistory("room", 5, 0)
+	server.EnableHistory("other", 5, 0)
+	c.Socket.SocketJoin("room")
+
+	// client does not poll, so emits to it block
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		for i := 0; i < 4; i++ {
+			server.To("room").Emit("event", i)
+		}
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("emits to full outbox are not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	joined := make(chan struct{})
+	go func() {
+		server.To("other").Emit("event")
+		newTestSocket(server).JoinWithHistory("empty", 10)
+		close(joined)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(time.Second):
+		t.Fatal("history is blocked by emit to slow socket")
+	}
+
+	c.close()
+	<-blocked
+}
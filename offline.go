@@ -0,0 +1,284 @@
+package siosver
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
+// OfflineMessage is an event emitted to a user who was not connected
+type OfflineMessage struct {
+	Args []interface{} `json:"args"` // event name and arguments
+	Time time.Time     `json:"time"`
+}
+
+// OfflineStore keeps messages emitted by Server.ToUser to users without
+// connected socket, until a socket of the user is bound by Socket.SetUser.
+// Messages are delivered at least once: if the socket closes before they are
+// written, they are stored again for next socket of the user. Errors of store
+// are reported to Server.OnError.
+type OfflineStore interface {
+	// Push stores message of user
+	Push(user string, msg OfflineMessage) error
+
+	// Pop removes messages of user and returns them in order
+	Pop(user string) ([]OfflineMessage, error)
+}
+
+// trimOfflineMessages drops messages older than ttl and oldest messages
+// exceeding maxLength. Zero ttl or maxLength means no limit.
+func trimOfflineMessages(messages []OfflineMessage, ttl time.Duration, maxLength int) []OfflineMessage {
+	if ttl > 0 {
+		expiry := time.Now().Add(-ttl)
+		i := 0
+		for i < len(messages) && messages[i].Time.Before(expiry) {
+			i++
+		}
+		messages = messages[i:]
+	}
+
+	if maxLength > 0 && len(messages) > maxLength {
+		messages = messages[len(messages)-maxLength:]
+	}
+	return messages
+}
+
+type memoryOfflineStore struct {
+	mtx       *sync.Mutex
+	messages  map[string][]OfflineMessage // key: user
+	ttl       time.Duration
+	maxLength int
+}
+
+// NewMemoryOfflineStore creates OfflineStore keeping messages in memory.
+// Zero ttl or maxLength means no limit.
+func NewMemoryOfflineStore(ttl time.Duration, maxLength int) OfflineStore {
+	return &memoryOfflineStore{
+		mtx:       &sync.Mutex{},
+		messages:  map[string][]OfflineMessage{},
+		ttl:       ttl,
+		maxLength: maxLength,
+	}
+}
+
+func (store *memoryOfflineStore) Push(user string, msg OfflineMessage) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	messages := append(store.messages[user], msg)
+	store.messages[user] = trimOfflineMessages(messages, store.ttl, store.maxLength)
+	return nil
+}
+
+func (store *memoryOfflineStore) Pop(user string) ([]OfflineMessage, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	messages := store.messages[user]
+	delete(store.messages, user)
+	return trimOfflineMessages(messages, store.ttl, 0), nil
+}
+
+type fileOfflineStore struct {
+	mtx       *sync.Mutex
+	dir       string
+	ttl       time.Duration
+	maxLength int
+}
+
+// NewFileOfflineStore creates OfflineStore keeping messages of each user as
+// JSON lines in a file in dir, so they survive restarts. Arguments of
+// messages must be JSON encodable. Zero ttl or maxLength means no limit.
+func NewFileOfflineStore(dir string, ttl time.Duration, maxLength int) (OfflineStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &fileOfflineStore{
+		mtx:       &sync.Mutex{},
+		dir:       dir,
+		ttl:       ttl,
+		maxLength: maxLength,
+	}, nil
+}
+
+// path returns file of user, hex encoded so any user id is a valid name
+func (store *fileOfflineStore) path(user string) string {
+	return filepath.Join(store.dir, hex.EncodeToString([]byte(user))+".jsonl")
+}
+
+func (store *fileOfflineStore) read(path string) ([]OfflineMessage, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []OfflineMessage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	messages := []OfflineMessage{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var msg OfflineMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
+
+func (store *fileOfflineStore) Push(user string, msg OfflineMessage) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	path := store.path(user)
+	messages, err := store.read(path)
+	if err != nil {
+		return err
+	}
+	messages = trimOfflineMessages(append(messages, msg), store.ttl, store.maxLength)
+
+	// write to temporary file and rename it, so file is never half written
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, msg := range messages {
+		if err = encoder.Encode(msg); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (store *fileOfflineStore) Pop(user string) ([]OfflineMessage, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	path := store.path(user)
+	messages, err := store.read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return trimOfflineMessages(messages, store.ttl, 0), nil
+}
+
+// storeOffline stores message of users having no connected socket,
+// server.offlineMtx must be locked
+func (server *Server) storeOffline(users []string, args []interface{}) {
+	server.roomsMtx.Lock()
+	offline := []string{}
+	for _, user := range users {
+		if _, isFound := server.rooms[userRoomName(user)]; !isFound {
+			offline = append(offline, user)
+		}
+	}
+	server.roomsMtx.Unlock()
+
+	if len(offline) == 0 {
+		return
+	}
+
+	// args of caller may be modified after emit
+	msg := OfflineMessage{Args: copyData(args).([]interface{}), Time: time.Now()}
+	for _, user := range offline {
+		if err := server.offlineStore.Push(user, msg); err != nil {
+			server.reportError(nil, "", fmt.Errorf("store offline message of user %s: %w", user, err))
+		}
+	}
+}
+
+// restoreOffline stores messages of user again, before messages stored
+// since they were popped
+func (server *Server) restoreOffline(user string, messages []OfflineMessage) {
+	server.offlineMtx.Lock()
+	defer server.offlineMtx.Unlock()
+
+	newer, err := server.offlineStore.Pop(user)
+	if err != nil {
+		server.reportError(nil, "", fmt.Errorf("restore offline messages of user %s: %w", user, err))
+	}
+	for _, msg := range append(messages, newer...) {
+		if err := server.offlineStore.Push(user, msg); err != nil {
+			server.reportError(nil, "", fmt.Errorf("restore offline messages of user %s: %w", user, err))
+			return
+		}
+	}
+}
+
+// popOffline removes stored messages of user to be delivered to socket and
+// locks socket.deliverMtx if there is any, server.offlineMtx must be locked
+func (socket *Socket) popOffline(user string) []OfflineMessage {
+	messages, err := socket.server.offlineStore.Pop(user)
+	if err != nil {
+		socket.server.reportError(socket, "", fmt.Errorf("pop offline messages of user %s: %w", user, err))
+		return nil
+	}
+	if len(messages) > 0 {
+		socket.deliverMtx.Lock()
+	}
+	return messages
+}
+
+// deliverOffline emits messages popped by popOffline and unlocks
+// socket.deliverMtx when they are queued. Messages are stored again if socket
+// closes before they are written.
+func (socket *Socket) deliverOffline(user string, messages []OfflineMessage) {
+	written := make(chan error, 1)
+	var err error
+	for i, msg := range messages {
+		flags := emitFlags{}
+		if i == len(messages)-1 {
+			flags.written = written
+		}
+		if err = socket.sendPacket(newPacket(__SIO_PACKET_EVENT, msg.Args...), flags); err != nil {
+			break
+		}
+	}
+	socket.deliverMtx.Unlock()
+
+	if err == nil {
+		select {
+		case err = <-written:
+		case <-socket.ctx.Done():
+			// last message may be written just before
+			select {
+			case err = <-written:
+			default:
+				err = engineio.ErrSocketClosed
+			}
+		}
+	}
+
+	// messages queued before the failed one may be lost with the socket too
+	if err != nil && socket.ctx.Err() != nil {
+		socket.server.restoreOffline(user, messages)
+	}
+}
@@ -0,0 +1,128 @@
+package siosver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type sqliteOfflineStore struct {
+	mtx       *sync.Mutex
+	db        *sql.DB
+	ttl       time.Duration
+	maxLength int
+}
+
+// NewSQLiteOfflineStore creates OfflineStore keeping messages in table
+// siosver_offline_messages of SQLite database db, so they survive restarts and
+// can be shared by processes using the same file. db is opened by caller with
+// a SQLite driver, e.g. github.com/mattn/go-sqlite3. Arguments of messages must
+// be JSON encodable. Zero ttl or maxLength means no limit.
+func NewSQLiteOfflineStore(db *sql.DB, ttl time.Duration, maxLength int) (OfflineStore, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS siosver_offline_messages (
+			id   INTEGER PRIMARY KEY AUTOINCREMENT,
+			user TEXT NOT NULL,
+			time INTEGER NOT NULL,
+			args TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS siosver_offline_messages_user
+			ON siosver_offline_messages (user, id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteOfflineStore{
+		mtx:       &sync.Mutex{},
+		db:        db,
+		ttl:       ttl,
+		maxLength: maxLength,
+	}, nil
+}
+
+func (store *sqliteOfflineStore) Push(user string, msg OfflineMessage) error {
+	args, err := json.Marshal(msg.Args)
+	if err != nil {
+		return err
+	}
+
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO siosver_offline_messages (user, time, args) VALUES (?, ?, ?)`,
+		user, msg.Time.UnixNano(), string(args))
+	if err != nil {
+		return err
+	}
+
+	if store.ttl > 0 {
+		_, err = tx.Exec(`DELETE FROM siosver_offline_messages WHERE user = ? AND time < ?`,
+			user, time.Now().Add(-store.ttl).UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	if store.maxLength > 0 {
+		_, err = tx.Exec(`
+			DELETE FROM siosver_offline_messages WHERE user = ? AND id NOT IN (
+				SELECT id FROM siosver_offline_messages WHERE user = ? ORDER BY id DESC LIMIT ?
+			)`, user, user, store.maxLength)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *sqliteOfflineStore) Pop(user string) ([]OfflineMessage, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT time, args FROM siosver_offline_messages WHERE user = ? ORDER BY id`, user)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []OfflineMessage{}
+	for rows.Next() {
+		var t int64
+		var args string
+		if err := rows.Scan(&t, &args); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		msg := OfflineMessage{Time: time.Unix(0, t)}
+		if err := json.Unmarshal([]byte(args), &msg.Args); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM siosver_offline_messages WHERE user = ?`, user); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return trimOfflineMessages(messages, store.ttl, 0), nil
+}
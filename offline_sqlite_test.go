@@ -0,0 +1,32 @@
+//go:build cgo
+// +build cgo
+
+package siosver
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLiteOfflineStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "offline.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewSQLiteOfflineStore(db, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOfflineStore(t, store)
+
+	// table exists already
+	if _, err := NewSQLiteOfflineStore(db, time.Hour, 2); err != nil {
+		t.Errorf("NewSQLiteOfflineStore() of existing table error = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package siosver
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOfflineStore(t *testing.T) {
+	fileStore, err := NewFileOfflineStore(t.TempDir(), time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]OfflineStore{
+		"memory": NewMemoryOfflineStore(time.Hour, 2),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testOfflineStore(t, store)
+		})
+	}
+}
+
+// testOfflineStore checks store created with ttl of an hour and maxLength 2
+func testOfflineStore(t *testing.T, store OfflineStore) {
+	now := time.Now()
+	store.Push("42", OfflineMessage{Args: []interface{}{"expired"}, Time: now.Add(-2 * time.Hour)})
+	for _, event := range []string{"dropped", "first", "second"} {
+		if err := store.Push("42", OfflineMessage{Args: []interface{}{event}, Time: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Push("43", OfflineMessage{Args: []interface{}{"other"}, Time: now})
+
+	messages, err := store.Pop("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Args[0] != "first" || messages[1].Args[0] != "second" {
+		t.Errorf("messages are %+v", messages)
+	}
+
+	if messages, _ := store.Pop("42"); len(messages) != 0 {
+		t.Errorf("messages are not removed, got %+v", messages)
+	}
+	if messages, _ := store.Pop("43"); len(messages) != 1 {
+		t.Errorf("messages of other user are %+v", messages)
+	}
+}
+
+func TestStoreOffline(t *testing.T) {
+	store := NewMemoryOfflineStore(0, 0)
+	server := NewServer(ServerOptions{OfflineStore: store})
+	socket := newTestSocket(server)
+	socket.SetUser("online")
+
+	server.storeOffline([]string{"online", "offline"}, []interface{}{"event", 1})
+
+	if messages, _ := store.Pop("online"); len(messages) != 0 {
+		t.Errorf("message of connected user is stored")
+	}
+	if messages, _ := store.Pop("offline"); len(messages) != 1 {
+		t.Errorf("message of offline user is not stored")
+	}
+}
+
+func TestOfflineDelivery(t *testing.T) {
+	store := NewMemoryOfflineStore(0, 0)
+	server := NewServer(ServerOptions{
+		PingInterval: 25000,
+		PingTimeout:  20000,
+		OfflineStore: store,
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	online := newTestClient(t, server, ts)
+	online.Socket.SetUser("43")
+
+	// buffer is sent to online user and kept for offline one
+	server.ToUser("42", "43").Emit("file", bytes.NewBufferString("abc"))
+	online.expect(`51-["file",{"_placeholder":true,"num":0}]`)
+	if attachment := online.next(); attachment != "bYWJj" {
+		t.Errorf("attachment is %q, want bYWJj", attachment)
+	}
+
+	// stored messages come before messages emitted after binding
+	c := newTestClient(t, server, ts)
+	c.Socket.SetUser("42")
+	server.ToUser("42").Emit("after")
+	c.expect(`51-["file",{"_placeholder":true,"num":0}]`)
+	if attachment := c.next(); attachment != "bYWJj" {
+		t.Errorf("attachment is %q, want bYWJj", attachment)
+	}
+	c.expect(`2["after"]`)
+
+	if messages, _ := store.Pop("42"); len(messages) != 0 {
+		t.Errorf("delivered messages are stored, got %+v", messages)
+	}
+}
+
+func TestOfflineRestore(t *testing.T) {
+	store := NewMemoryOfflineStore(0, 0)
+	store.Push("42", OfflineMessage{Args: []interface{}{"stored"}, Time: time.Now()})
+	_, c := newTestServerClient(t, ServerOptions{OfflineStore: store})
+
+	// client closes without polling stored message
+	c.Socket.SetUser("42")
+	c.close()
+
+	for i := 0; ; i++ {
+		messages, _ := store.Pop("42")
+		if len(messages) == 1 && messages[0].Args[0] == "stored" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("messages are not stored again, got %+v", messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOfflineEmitNotBlocked(t *testing.T) {
+	store := NewMemoryOfflineStore(0, 0)
+	server, c := newTestServerClient(t, ServerOptions{OfflineStore: store, OutboxSize: 1})
+	c.Socket.SetUser("42")
+
+	// client does not poll, so emits to it block
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		for i := 0; i < 4; i++ {
+			server.ToUser("42").Emit("event", i)
+		}
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("emits to full outbox are not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stored := make(chan struct{})
+	go func() {
+		server.ToUser("43").Emit("event")
+		close(stored)
+	}()
+	select {
+	case <-stored:
+	case <-time.After(time.Second):
+		t.Fatal("emit to offline user is blocked by emit to slow socket")
+	}
+
+	c.close()
+	<-blocked
+}
+
+var errOfflineStore = errors.New("offline store is broken")
+
+// brokenOfflineStore fails every operation
+type brokenOfflineStore struct{}
+
+func (brokenOfflineStore) Push(user string, msg OfflineMessage) error {
+	return errOfflineStore
+}
+
+func (brokenOfflineStore) Pop(user string) ([]OfflineMessage, error) {
+	return nil, errOfflineStore
+}
+
+func TestOfflineStoreError(t *testing.T) {
+	server := NewServer(ServerOptions{OfflineStore: brokenOfflineStore{}})
+	errs := make(chan error, 2)
+	server.OnError(func(socket *Socket, event string, err error) {
+		errs <- err
+	})
+
+	server.ToUser("42").Emit("event")
+	newTestSocket(server).SetUser("42")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, errOfflineStore) {
+				t.Errorf("reported error is %v, want %v", err, errOfflineStore)
+			}
+		default:
+			t.Fatal("error of store is not reported")
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
 )
 
@@ -69,6 +70,55 @@ func (p *packet) withAck(ackId int) *packet {
 	return p
 }
 
+// copyData copies maps and slices of v, and buffers in them, so encoding it
+// does not modify data of caller, e.g. args kept by history or offline store
+func copyData(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(v)).Interface()
+}
+
+func copyValue(rv reflect.Value) reflect.Value {
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.New(rv.Type()).Elem()
+		copied.Set(copyValue(rv.Elem()))
+		return copied
+
+	case reflect.Ptr:
+		if buf, isOk := rv.Interface().(*bytes.Buffer); isOk && buf != nil {
+			return reflect.ValueOf(bytes.NewBuffer(append([]byte{}, buf.Bytes()...)))
+		}
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return copied
+
+	case reflect.Slice:
+		// []byte is not modified by encoding
+		if rv.IsNil() || rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv
+		}
+		copied := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			copied.Index(i).Set(copyValue(rv.Index(i)))
+		}
+		return copied
+	}
+	return rv
+}
+
 func (p *packet) encode() (data string, buffers [](*bytes.Buffer)) {
 	// TODO : what if packet type is binary
 
@@ -76,8 +126,9 @@ func (p *packet) encode() (data string, buffers [](*bytes.Buffer)) {
 	buffers = [](*bytes.Buffer){}
 	isBinaryPacket := false
 
-	// check buffers data
-	sioPacketGetBuffer(&buffers, &(p.data))
+	// check buffers data of copy, as buffers are replaced by placeholders
+	pdata := copyData(p.data)
+	sioPacketGetBuffer(&buffers, &pdata)
 	if len(buffers) > 0 {
 		switch p.packetType {
 		case __SIO_PACKET_EVENT:
@@ -106,8 +157,10 @@ func (p *packet) encode() (data string, buffers [](*bytes.Buffer)) {
 		fmt.Fprintf(&buf, "%d", p.ackId)
 	}
 
-	rawdata, _ := json.Marshal(p.data)
-	buf.Write(rawdata)
+	if pdata != nil {
+		rawdata, _ := json.Marshal(pdata)
+		buf.Write(rawdata)
+	}
 
 	data = buf.String()
 	return
@@ -0,0 +1,163 @@
+package siosver
+
+// Presence events, see Server.OnRoomEvent
+const (
+	ROOM_EVENT_PRESENCE_JOIN   = "presence-join"   // args: *Room, PresenceMember
+	ROOM_EVENT_PRESENCE_LEAVE  = "presence-leave"  // args: *Room, PresenceMember
+	ROOM_EVENT_PRESENCE_UPDATE = "presence-update" // args: *Room, PresenceMember
+)
+
+// PresenceOptions configures presence events broadcast to rooms. Empty event
+// name disables the event.
+//
+// Presence is tracked by each server process for its own sockets. There is
+// no adapter sharing rooms between nodes of a cluster, so members connected
+// to other nodes are not listed and their events are not broadcast.
+type PresenceOptions struct {
+	// UserKey returns key of user of socket, so sockets of the same user
+	// (e.g. browser tabs) count once. Default is user id bound by
+	// Socket.SetUser, or socket id if not bound. Keys are taken when socket
+	// joins a room and again when Socket.SetUser changes its user. It is
+	// called while rooms are locked, so it must not use rooms, presence or
+	// Socket.User.
+	UserKey func(*Socket) string
+
+	JoinEvent   string // when first socket of user joins the room
+	LeaveEvent  string // when last socket of user leaves the room
+	UpdateEvent string // when state of member is updated
+}
+
+// PresenceMember is a user in a room
+type PresenceMember struct {
+	Key     string
+	State   interface{} // set by Presence.Update
+	Sockets int         // number of sockets of user in the room
+}
+
+// Presence tracks users in a room
+type Presence struct {
+	server   *Server
+	roomName string
+}
+
+// Presence returns presence of room
+func (server *Server) Presence(roomName string) *Presence {
+	return &Presence{server: server, roomName: roomName}
+}
+
+// List returns users in room
+func (presence *Presence) List() []PresenceMember {
+	presence.server.roomsMtx.Lock()
+	defer presence.server.roomsMtx.Unlock()
+
+	room, isFound := presence.server.rooms[presence.roomName]
+	if !isFound {
+		return []PresenceMember{}
+	}
+
+	members := make([]PresenceMember, 0, len(room.members))
+	for _, member := range room.members {
+		members = append(members, *member)
+	}
+	return members
+}
+
+// Update sets state of user in room and broadcasts it. It returns false if
+// user is not in room.
+func (presence *Presence) Update(key string, state interface{}) bool {
+	presence.server.roomsMtx.Lock()
+	defer presence.server.unlockRooms()
+
+	room, isFound := presence.server.rooms[presence.roomName]
+	if !isFound {
+		return false
+	}
+	member, isFound := room.members[key]
+	if !isFound {
+		return false
+	}
+
+	member.State = state
+	presence.server.queueRoomEvent(ROOM_EVENT_PRESENCE_UPDATE, room, *member)
+	return true
+}
+
+// userKey returns presence key of socket, server.roomsMtx must be locked
+func (server *Server) userKey(socket *Socket) string {
+	if server.presenceOptions != nil && server.presenceOptions.UserKey != nil {
+		return server.presenceOptions.UserKey(socket)
+	}
+	if socket.user != "" {
+		return socket.user
+	}
+	return socket.ID()
+}
+
+// addMember counts socket as member of room, server.roomsMtx must be locked
+func (room *Room) addMember(socket *Socket) {
+	key := room.server.userKey(socket)
+	room.memberKeys[socket.id] = key
+
+	member, isFound := room.members[key]
+	if !isFound {
+		member = &PresenceMember{Key: key}
+		room.members[key] = member
+	}
+	member.Sockets++
+
+	if !isFound {
+		room.server.queueRoomEvent(ROOM_EVENT_PRESENCE_JOIN, room, *member)
+	}
+}
+
+// removeMember uncounts socket as member of room, server.roomsMtx must be
+// locked
+func (room *Room) removeMember(socket *Socket) {
+	key, isFound := room.memberKeys[socket.id]
+	if !isFound {
+		return
+	}
+	delete(room.memberKeys, socket.id)
+
+	member := room.members[key]
+	member.Sockets--
+	if member.Sockets == 0 {
+		delete(room.members, key)
+		room.server.queueRoomEvent(ROOM_EVENT_PRESENCE_LEAVE, room, *member)
+	}
+}
+
+// rekeyMember counts socket as member under its current user key, e.g.
+// after Socket.SetUser, server.roomsMtx must be locked
+func (room *Room) rekeyMember(socket *Socket) {
+	key, isFound := room.memberKeys[socket.id]
+	if !isFound || key == room.server.userKey(socket) {
+		return
+	}
+	room.removeMember(socket)
+	room.addMember(socket)
+}
+
+// broadcastPresence emits presence events of rooms to their sockets
+func (server *Server) broadcastPresence(opt *PresenceOptions) {
+	broadcast := func(event string) func(...interface{}) {
+		return func(args ...interface{}) {
+			room := args[0].(*Room)
+			member := args[1].(PresenceMember)
+			server.To(room.Name).Emit(event, map[string]interface{}{
+				"key":   member.Key,
+				"state": member.State,
+			})
+		}
+	}
+
+	if opt.JoinEvent != "" {
+		server.OnRoomEvent(ROOM_EVENT_PRESENCE_JOIN, broadcast(opt.JoinEvent))
+	}
+	if opt.LeaveEvent != "" {
+		server.OnRoomEvent(ROOM_EVENT_PRESENCE_LEAVE, broadcast(opt.LeaveEvent))
+	}
+	if opt.UpdateEvent != "" {
+		server.OnRoomEvent(ROOM_EVENT_PRESENCE_UPDATE, broadcast(opt.UpdateEvent))
+	}
+}
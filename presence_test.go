@@ -0,0 +1,125 @@
+package siosver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	server := NewServer(ServerOptions{
+		Presence: &PresenceOptions{
+			UserKey: func(socket *Socket) string {
+				user, _ := socket.Get("user").(string)
+				return user
+			},
+		},
+	})
+
+	events := make(chan string, 10)
+	for _, event := range []string{ROOM_EVENT_PRESENCE_JOIN, ROOM_EVENT_PRESENCE_LEAVE, ROOM_EVENT_PRESENCE_UPDATE} {
+		event := event
+		server.OnRoomEvent(event, func(args ...interface{}) {
+			events <- event + " " + args[1].(PresenceMember).Key
+		})
+	}
+
+	tab1 := newTestSocket(server)
+	tab2 := newTestSocket(server)
+	other := newTestSocket(server)
+	tab1.Set("user", "alice")
+	tab2.Set("user", "alice")
+	other.Set("user", "bob")
+
+	tab1.SocketJoin("room")
+	tab2.SocketJoin("room")
+	other.SocketJoin("room")
+
+	members := server.Presence("room").List()
+	if len(members) != 2 {
+		t.Fatalf("room has %d members, want 2", len(members))
+	}
+	for _, member := range members {
+		if member.Key == "alice" && member.Sockets != 2 {
+			t.Errorf("alice has %d sockets, want 2", member.Sockets)
+		}
+	}
+
+	if !server.Presence("room").Update("bob", "away") {
+		t.Error("state of member is not updated")
+	}
+	if server.Presence("room").Update("carol", "away") {
+		t.Error("state of unknown member is updated")
+	}
+
+	tab1.SocketLeave("room")
+	tab2.disconnect(false)
+
+	want := []string{
+		"presence-join alice",
+		"presence-join bob",
+		"presence-update bob",
+		"presence-leave alice",
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("got event %q, want %q", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not emitted", w)
+		}
+	}
+
+	members = server.Presence("room").List()
+	if len(members) != 1 || members[0].Key != "bob" || members[0].State != "away" {
+		t.Errorf("members are %+v", members)
+	}
+}
+
+func TestPresenceSetUser(t *testing.T) {
+	server := NewServer(ServerOptions{Presence: &PresenceOptions{}})
+
+	events := make(chan string, 10)
+	for _, event := range []string{ROOM_EVENT_PRESENCE_JOIN, ROOM_EVENT_PRESENCE_LEAVE} {
+		event := event
+		server.OnRoomEvent(event, func(args ...interface{}) {
+			events <- event + " " + args[1].(PresenceMember).Key
+		})
+	}
+
+	// tabs join before user is bound, so they are keyed by socket id
+	tab1 := newTestSocket(server)
+	tab2 := newTestSocket(server)
+	tab1.SocketJoin("room")
+	tab2.SocketJoin("room")
+	if n := len(server.Presence("room").List()); n != 2 {
+		t.Fatalf("room has %d members, want 2", n)
+	}
+
+	tab1.setUser("alice")
+	tab2.setUser("alice")
+
+	members := server.Presence("room").List()
+	if len(members) != 1 || members[0].Key != "alice" || members[0].Sockets != 2 {
+		t.Fatalf("members are %+v, want alice with 2 sockets", members)
+	}
+
+	want := []string{
+		"presence-join " + tab1.ID(),
+		"presence-join " + tab2.ID(),
+		"presence-leave " + tab1.ID(),
+		"presence-join alice",
+		"presence-leave " + tab2.ID(),
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("got event %q, want %q", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not emitted", w)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package siosver
+
+import "github.com/ghuvrons/siosver/engineio"
+
+// OnError add handler of panics recovered in handlers, and errors returned by
+// handlers of OnWithError which can not be acknowledged, and errors of
+// ServerOptions.OfflineStore. Socket is nil and event is empty if error is not
+// of a socket event.
+func (server *Server) OnError(f func(socket *Socket, event string, err error)) {
+	server.handlers.error = f
+}
+
+// reportError calls error handler, ignoring its own panic
+func (server *Server) reportError(socket *Socket, event string, err error) {
+	if server == nil || server.handlers.error == nil {
+		return
+	}
+	defer func() { recover() }()
+	server.handlers.error(socket, event, err)
+}
+
+// recoverPanic recovers panic of handler of event and reports it. It must be
+// deferred directly.
+func (server *Server) recoverPanic(socket *Socket, event string) {
+	if r := recover(); r != nil {
+		server.reportError(socket, event, engineio.NewPanicError(r))
+	}
+}
+
+// runHandler runs handler of event and reports its panic
+func (socket *Socket) runHandler(event string, f func()) {
+	defer socket.server.recoverPanic(socket, event)
+	f()
+}
+
+// errorPayload returns err as sent to client, {"message": ...} unless err is
+// *RPCError
+func errorPayload(err error) *RPCError {
+	if rpcErr, isOk := err.(*RPCError); isOk {
+		return rpcErr
+	}
+	return &RPCError{Message: err.Error()}
+}
+
+// OnWithError add handler of event which may return error. If client asks
+// for acknowledgement, the error is sent as acknowledgement {"message": ...},
+// otherwise it is reported to Server.OnError. Last argument is ack callback
+// if client asks for acknowledgement. It returns func removing the handler.
+func (socket *Socket) OnWithError(event string, f func(...interface{}) error) func() {
+	return socket.On(event, func(args ...interface{}) {
+		err := f(args...)
+		if err == nil {
+			return
+		}
+
+		if len(args) > 0 {
+			if ack, isAck := args[len(args)-1].(func(...interface{})); isAck {
+				ack(errorPayload(err))
+				return
+			}
+		}
+		socket.server.reportError(socket, event, err)
+	})
+}
@@ -0,0 +1,72 @@
+package siosver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
+func TestRecoverHandlerPanic(t *testing.T) {
+	server := NewServer(ServerOptions{})
+
+	var reported error
+	var reportedSocket *Socket
+	var reportedEvent string
+	server.OnError(func(socket *Socket, event string, err error) {
+		reportedSocket, reportedEvent, reported = socket, event, err
+	})
+
+	socket := newTestSocket(server)
+	socket.On("boom", func(args ...interface{}) {
+		panic("boom")
+	})
+
+	socket.onMessage(newPacket(__SIO_PACKET_EVENT, "boom", 1))
+
+	panicErr, isPanic := reported.(*engineio.PanicError)
+	if !isPanic {
+		t.Fatalf("reported %v, expected *engineio.PanicError", reported)
+	}
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Errorf("reported panic %v with stack of %d bytes", panicErr.Value, len(panicErr.Stack))
+	}
+	if reportedSocket != socket || reportedEvent != "boom" {
+		t.Errorf("reported socket %v and event %q", reportedSocket, reportedEvent)
+	}
+}
+
+func TestOnWithError(t *testing.T) {
+	server := NewServer(ServerOptions{})
+
+	var reported error
+	server.OnError(func(socket *Socket, event string, err error) {
+		reported = err
+	})
+
+	failure := errors.New("failure")
+	socket := newTestSocket(server)
+	socket.OnWithError("fail", func(args ...interface{}) error {
+		return failure
+	})
+
+	// acknowledged error is not reported
+	var acked []interface{}
+	socket.eventEmitter.Emit("fail", 1, func(args ...interface{}) {
+		acked = args
+	})
+	if len(acked) != 1 {
+		t.Fatalf("acked %v", acked)
+	}
+	if rpcErr, isOk := acked[0].(*RPCError); !isOk || rpcErr.Message != "failure" {
+		t.Errorf("acked %v, expected {\"message\": \"failure\"}", acked[0])
+	}
+	if reported != nil {
+		t.Errorf("acknowledged error is reported: %v", reported)
+	}
+
+	socket.eventEmitter.Emit("fail", 1)
+	if reported != failure {
+		t.Errorf("reported %v, expected %v", reported, failure)
+	}
+}
@@ -1,22 +1,191 @@
 package siosver
 
+import (
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Room events, see Server.OnRoomEvent
+const (
+	ROOM_EVENT_CREATE = "create-room" // args: *Room
+	ROOM_EVENT_DELETE = "delete-room" // args: *Room
+	ROOM_EVENT_JOIN   = "join-room"   // args: *Room, *Socket
+	ROOM_EVENT_LEAVE  = "leave-room"  // args: *Room, *Socket
+)
+
 type Room struct {
 	Name    string
-	sockets Sockets
+	server  *Server
+	sockets Sockets // guarded by server.roomsMtx
+
+	// metadata set by Set
+	data    map[string]interface{}
+	dataMtx *sync.Mutex
+
+	// presence, guarded by server.roomsMtx
+	members    map[string]*PresenceMember // key: user key
+	memberKeys map[uuid.UUID]string       // user key of sockets
 }
 
+// USER_ROOM_PREFIX prefixes names of rooms of users bound by Socket.SetUser
+const USER_ROOM_PREFIX = "user:"
+
+func userRoomName(id string) string {
+	return USER_ROOM_PREFIX + id
+}
+
+type roomEvent struct {
+	name string
+	args []interface{}
+}
+
+// join adds socket to room, server.roomsMtx must be locked
 func (room *Room) join(socket *Socket) {
+	if _, isFound := room.sockets[socket.id]; isFound {
+		return
+	}
 	room.sockets[socket.id] = socket
 	socket.rooms[room.Name] = room
+	room.server.queueRoomEvent(ROOM_EVENT_JOIN, room, socket)
+
+	// private rooms of socket and user have no presence
+	if room.Name != socket.ID() && !strings.HasPrefix(room.Name, USER_ROOM_PREFIX) {
+		room.addMember(socket)
+	}
 }
 
+// leave removes socket from room and deletes the room if it becomes empty,
+// server.roomsMtx must be locked
 func (room *Room) leave(socket *Socket) {
+	if _, isFound := room.sockets[socket.id]; !isFound {
+		return
+	}
 	delete(room.sockets, socket.id)
+	delete(socket.rooms, room.Name)
+	room.server.queueRoomEvent(ROOM_EVENT_LEAVE, room, socket)
+	room.removeMember(socket)
+
+	if len(room.sockets) == 0 {
+		room.delete()
+	}
+}
+
+// delete removes room from server, server.roomsMtx must be locked
+func (room *Room) delete() {
+	if room.server.rooms[room.Name] != room {
+		return
+	}
+	delete(room.server.rooms, room.Name)
+	room.server.queueRoomEvent(ROOM_EVENT_DELETE, room)
+}
+
+// Set stores metadata of room
+func (room *Room) Set(key string, value interface{}) {
+	room.dataMtx.Lock()
+	defer room.dataMtx.Unlock()
+	room.data[key] = value
+}
+
+// Get returns metadata of room stored by Set, nil if not found
+func (room *Room) Get(key string) interface{} {
+	room.dataMtx.Lock()
+	defer room.dataMtx.Unlock()
+	return room.data[key]
+}
+
+// Data returns copy of metadata of room
+func (room *Room) Data() map[string]interface{} {
+	room.dataMtx.Lock()
+	defer room.dataMtx.Unlock()
+
+	data := make(map[string]interface{}, len(room.data))
+	for key, value := range room.data {
+		data[key] = value
+	}
+	return data
+}
+
+// Sockets returns sockets in room
+func (room *Room) Sockets() Sockets {
+	room.server.roomsMtx.Lock()
+	defer room.server.roomsMtx.Unlock()
+
+	sockets := make(Sockets, len(room.sockets))
+	for id, socket := range room.sockets {
+		sockets[id] = socket
+	}
+	return sockets
+}
+
+// Len returns number of sockets in room
+func (room *Room) Len() int {
+	room.server.roomsMtx.Lock()
+	defer room.server.roomsMtx.Unlock()
+	return len(room.sockets)
 }
 
 func (room *Room) Emit(arg ...interface{}) {
-	packet := newPacket(__SIO_PACKET_EVENT, arg...)
-	for _, socket := range room.sockets {
-		socket.send(packet)
+	(&BroadcastOperator{server: room.server, rooms: []*Room{room}}).Emit(arg...)
+}
+
+// Volatile makes emitted data dropped by sockets not ready to receive it
+func (room *Room) Volatile() *BroadcastOperator {
+	return (&BroadcastOperator{server: room.server, rooms: []*Room{room}}).Volatile()
+}
+
+// Compress sets whether emitted data to the room will be compressed
+func (room *Room) Compress(compress bool) *BroadcastOperator {
+	return (&BroadcastOperator{server: room.server, rooms: []*Room{room}}).Compress(compress)
+}
+
+// OnRoomEvent adds handler of room event, e.g. ROOM_EVENT_CREATE. Handlers
+// run in order of events, in a goroutine other than the one changing rooms.
+// It returns func removing the handler.
+func (server *Server) OnRoomEvent(event string, f func(...interface{})) func() {
+	return server.roomEvents.On(event, f)
+}
+
+// queueRoomEvent queues event to be emitted by unlockRooms,
+// server.roomsMtx must be locked
+func (server *Server) queueRoomEvent(name string, args ...interface{}) {
+	server.roomEventsQueue = append(server.roomEventsQueue, roomEvent{name, args})
+}
+
+// unlockRooms unlocks server.roomsMtx and emits queued room events
+func (server *Server) unlockRooms() {
+	isStarting := len(server.roomEventsQueue) > 0 && !server.isEmittingRoom
+	if isStarting {
+		server.isEmittingRoom = true
+	}
+	server.roomsMtx.Unlock()
+
+	if isStarting {
+		go server.emitRoomEvents()
+	}
+}
+
+// emitRoomEvent emits room event and reports panic of its handlers
+func (server *Server) emitRoomEvent(event roomEvent) {
+	defer server.recoverPanic(nil, event.name)
+	server.roomEvents.Emit(event.name, event.args...)
+}
+
+func (server *Server) emitRoomEvents() {
+	for {
+		server.roomsMtx.Lock()
+		events := server.roomEventsQueue
+		server.roomEventsQueue = nil
+		if len(events) == 0 {
+			server.isEmittingRoom = false
+			server.roomsMtx.Unlock()
+			return
+		}
+		server.roomsMtx.Unlock()
+
+		for _, event := range events {
+			server.emitRoomEvent(event)
+		}
 	}
 }
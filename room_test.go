@@ -0,0 +1,233 @@
+package siosver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSocket(server *Server) *Socket {
+	socket := newSocket(server, "/", context.Background())
+	server.addSocket(socket)
+	return socket
+}
+
+// checkRegistry checks that rooms of sockets and sockets of rooms match
+func checkRegistry(t *testing.T, server *Server) {
+	t.Helper()
+
+	server.roomsMtx.Lock()
+	defer server.roomsMtx.Unlock()
+
+	for name, room := range server.rooms {
+		if room.Name != name {
+			t.Errorf("room %q is registered as %q", room.Name, name)
+		}
+		for _, socket := range room.sockets {
+			if socket.rooms[name] != room {
+				t.Errorf("socket %s is in room %q but the room is not in socket", socket.id, name)
+			}
+			if socket.isClosed {
+				t.Errorf("closed socket %s is in room %q", socket.id, name)
+			}
+		}
+	}
+
+	for _, socket := range server.sockets {
+		for name, room := range socket.rooms {
+			if server.rooms[name] != room {
+				t.Errorf("socket %s has deleted room %q", socket.id, name)
+			}
+			if _, isFound := room.sockets[socket.id]; !isFound {
+				t.Errorf("room %q is in socket %s but the socket is not in room", name, socket.id)
+			}
+		}
+	}
+}
+
+func TestRoomJoinLeave(t *testing.T) {
+	server := NewServer(ServerOptions{})
+	a := newTestSocket(server)
+	b := newTestSocket(server)
+
+	a.SocketJoin("room")
+	b.SocketJoin("room")
+	if n := server.Room("room").Len(); n != 2 {
+		t.Fatalf("room has %d sockets, want 2", n)
+	}
+
+	a.SocketLeave("room")
+	if _, isFound := a.rooms["room"]; isFound {
+		t.Error("room is not removed from left socket")
+	}
+
+	b.SocketLeave("room")
+	if server.Room("room") != nil {
+		t.Error("empty room is not deleted")
+	}
+
+	a.SocketJoin("other")
+	server.DeleteRoom("other")
+	if len(a.rooms) != 0 {
+		t.Errorf("socket has %d rooms after room deleted", len(a.rooms))
+	}
+
+	b.SocketJoin("room")
+	b.disconnect(false)
+	if server.Room("room") != nil {
+		t.Error("room of disconnected socket is not deleted")
+	}
+	if _, isFound := server.Sockets()[b.id]; isFound {
+		t.Error("disconnected socket is still registered")
+	}
+
+	b.SocketJoin("room")
+	if server.Room("room") != nil {
+		t.Error("disconnected socket joined room")
+	}
+
+	checkRegistry(t, server)
+}
+
+func TestRoomConcurrency(t *testing.T) {
+	const numOfSockets = 50
+	const numOfRooms = 5
+
+	server := NewServer(ServerOptions{})
+	sockets := []*Socket{}
+	for i := 0; i < numOfSockets; i++ {
+		sockets = append(sockets, newTestSocket(server))
+	}
+
+	wg := &sync.WaitGroup{}
+	for i, socket := range sockets {
+		wg.Add(1)
+		go func(i int, socket *Socket) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				roomName := fmt.Sprint("room", (i+j)%numOfRooms)
+				socket.SocketJoin(roomName)
+				server.CreateRoom(roomName).Sockets()
+				if j%3 == 0 {
+					socket.SocketLeave(roomName)
+				}
+			}
+			if i%2 == 0 {
+				socket.disconnect(false)
+			}
+		}(i, socket)
+	}
+
+	// broadcasts, deletes and lookups meanwhile
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 100; j++ {
+			roomName := fmt.Sprint("room", j%numOfRooms)
+			(&BroadcastOperator{rooms: []*Room{server.CreateRoom(roomName)}}).targets()
+			server.Rooms()
+			server.Sockets()
+			if j%10 == 0 {
+				server.DeleteRoom(roomName)
+			}
+		}
+	}()
+	wg.Wait()
+
+	checkRegistry(t, server)
+	for i, socket := range sockets {
+		_, isFound := server.Sockets()[socket.id]
+		if i%2 == 0 && isFound {
+			t.Errorf("disconnected socket %d is still registered", i)
+		}
+		if i%2 == 1 && !isFound {
+			t.Errorf("connected socket %d is not registered", i)
+		}
+	}
+}
+
+func TestServerTo(t *testing.T) {
+	server := NewServer(ServerOptions{})
+	a := newTestSocket(server)
+	b := newTestSocket(server)
+	a.SocketJoin(a.ID())
+	b.SocketJoin(b.ID())
+	b.SocketJoin("room")
+
+	if server.Socket(a.ID()) != a {
+		t.Error("socket is not found by id")
+	}
+	if server.Socket("unknown") != nil {
+		t.Error("unknown socket is found")
+	}
+
+	targets := server.To(a.ID()).targets()
+	if len(targets) != 1 || targets[a.id] != a {
+		t.Errorf("targets of socket id are %v", targets)
+	}
+
+	targets = server.To(a.ID(), "room", "unknown").targets()
+	if len(targets) != 2 {
+		t.Errorf("targets of rooms are %v", targets)
+	}
+}
+
+func TestRoomEvents(t *testing.T) {
+	server := NewServer(ServerOptions{})
+	events := make(chan string, 10)
+	for _, event := range []string{ROOM_EVENT_CREATE, ROOM_EVENT_DELETE, ROOM_EVENT_JOIN, ROOM_EVENT_LEAVE} {
+		event := event
+		server.OnRoomEvent(event, func(args ...interface{}) {
+			events <- event + " " + args[0].(*Room).Name
+		})
+	}
+
+	socket := newTestSocket(server)
+	socket.SocketJoin("room")
+	server.Room("room").Set("topic", "news")
+	if server.Rooms()["room"].Get("topic") != "news" {
+		t.Error("metadata of room is not set")
+	}
+	socket.SocketJoin("room")
+	socket.SocketLeave("room")
+
+	want := []string{"create-room room", "join-room room", "leave-room room", "delete-room room"}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("got event %q, want %q", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not emitted", w)
+		}
+	}
+}
+
+func TestServerToUser(t *testing.T) {
+	server := NewServer(ServerOptions{})
+	phone := newTestSocket(server)
+	laptop := newTestSocket(server)
+	other := newTestSocket(server)
+	phone.SetUser("42")
+	laptop.SetUser("42")
+	other.SetUser("43")
+
+	targets := server.ToUser("42").targets()
+	if len(targets) != 2 || targets[phone.id] != phone || targets[laptop.id] != laptop {
+		t.Errorf("targets of user are %v", targets)
+	}
+
+	other.SetUser("42")
+	other.SetUser("")
+	if other.User() != "" || len(other.Rooms()) != 0 {
+		t.Errorf("unbound socket has user %q and rooms %v", other.User(), other.Rooms())
+	}
+	if n := len(server.ToUser("42", "43").targets()); n != 2 {
+		t.Errorf("users have %d sockets, want 2", n)
+	}
+
+	checkRegistry(t, server)
+}
@@ -0,0 +1,140 @@
+package siosver
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
+// RPC over events with acknowledgement. A call emits event named after the
+// method with request as single argument, and the callee acknowledges with
+// (error, response), error being null or RPCError.
+
+// RPCError is error returned by remote procedure
+type RPCError struct {
+	Message string      `json:"message"`
+	Code    string      `json:"code,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (err *RPCError) Error() string {
+	return err.Message
+}
+
+// RPCRequest is request of call handled by Socket.Handle
+type RPCRequest struct {
+	Method string
+	args   interface{}
+}
+
+// Decode decodes JSON of request to v
+func (req *RPCRequest) Decode(v interface{}) error {
+	return remarshal(req.args, v)
+}
+
+// RPCHandler handles call of a method. Context is cancelled when socket
+// disconnects.
+type RPCHandler func(ctx context.Context, req *RPCRequest) (interface{}, error)
+
+// remarshal decodes decoded JSON value src to v
+func remarshal(src interface{}, v interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Call calls method of client and decodes its response to reply, which may be
+// nil. It returns *RPCError if client responds with error, ctx.Err() if ctx
+// is done and engineio.ErrSocketClosed if socket disconnects.
+func (socket *Socket) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	acked := make(chan []interface{}, 1)
+	id, err := socket.emitWithAck([]interface{}{method, args}, emitFlags{}, func(ackArgs []interface{}) {
+		acked <- ackArgs
+	})
+	if err != nil {
+		return err
+	}
+
+	var ackArgs []interface{}
+	select {
+	case ackArgs = <-acked:
+	case <-ctx.Done():
+		socket.cancelAck(id)
+		return ctx.Err()
+	case <-socket.ctx.Done():
+		socket.cancelAck(id)
+		return engineio.ErrSocketClosed
+	}
+
+	if len(ackArgs) > 0 && ackArgs[0] != nil {
+		rpcErr := &RPCError{}
+		if err := remarshal(ackArgs[0], rpcErr); err != nil || rpcErr.Message == "" {
+			rpcErr.Message = "remote error"
+			rpcErr.Data = ackArgs[0]
+		}
+		return rpcErr
+	}
+
+	if reply == nil || len(ackArgs) < 2 {
+		return nil
+	}
+	return remarshal(ackArgs[1], reply)
+}
+
+// Handle registers handler of method called by client. Response is sent as
+// acknowledgement (null, response), or (error, null) if handler fails. Once a
+// method is registered, call of method having neither handler nor event
+// listener fails with "unknown method". Handlers run in order with handlers of
+// events of the socket, and may wait Call of the same socket.
+func (socket *Socket) Handle(method string, f RPCHandler) {
+	socket.acksMtx.Lock()
+	defer socket.acksMtx.Unlock()
+	socket.rpcHandlers[method] = f
+}
+
+// rpcHandler returns handler of method, nil if not found, and whether socket
+// handles any method
+func (socket *Socket) rpcHandler(method string) (RPCHandler, bool) {
+	socket.acksMtx.Lock()
+	defer socket.acksMtx.Unlock()
+	return socket.rpcHandlers[method], len(socket.rpcHandlers) > 0
+}
+
+// handleCall runs handler of call and acknowledges its result if ackId is
+// not negative
+func (socket *Socket) handleCall(f RPCHandler, method string, args []interface{}, ackId int) {
+	req := &RPCRequest{Method: method}
+	if len(args) > 0 {
+		req.args = args[0]
+	}
+
+	resp, err := socket.callHandler(f, req)
+	if ackId < 0 {
+		if err != nil {
+			socket.server.reportError(socket, method, err)
+		}
+		return
+	}
+
+	var ack *packet
+	if err != nil {
+		ack = newPacket(__SIO_PACKET_ACK, errorPayload(err), nil)
+	} else {
+		ack = newPacket(__SIO_PACKET_ACK, nil, resp)
+	}
+	socket.send(ack.withAck(ackId))
+}
+
+// callHandler runs handler and reports its panic, which fails the call
+func (socket *Socket) callHandler(f RPCHandler, req *RPCRequest) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			socket.server.reportError(socket, req.Method, engineio.NewPanicError(r))
+			resp, err = nil, &RPCError{Message: "internal error"}
+		}
+	}()
+	return f(socket.ctx, req)
+}
@@ -0,0 +1,191 @@
+package siosver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
+// call runs Call in background and returns channel of its error
+func call(ctx context.Context, socket *Socket, method string, args interface{}, reply interface{}) chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- socket.Call(ctx, method, args, reply)
+	}()
+	return result
+}
+
+// waitResult returns error received from result
+func waitResult(t *testing.T, result chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("call is not finished")
+		return nil
+	}
+}
+
+func TestCall(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	var reply int
+	result := call(context.Background(), c.Socket, "sum", []int{1, 2}, &reply)
+	c.expect(`20["sum",[1,2]]`)
+	c.send(`30[null,3]`)
+	if err := waitResult(t, result); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	if reply != 3 {
+		t.Errorf("reply = %d, want 3", reply)
+	}
+
+	result = call(context.Background(), c.Socket, "sum", nil, &reply)
+	c.expect(`21["sum",null]`)
+	c.send(`31[{"message":"denied","code":"E403"}]`)
+	err := waitResult(t, result)
+	rpcErr, isOk := err.(*RPCError)
+	if !isOk || rpcErr.Message != "denied" || rpcErr.Code != "E403" {
+		t.Errorf("Call() error = %#v, want RPCError denied E403", err)
+	}
+}
+
+func TestCallCancelled(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result := call(ctx, c.Socket, "slow", nil, nil)
+	c.expect(`20["slow",null]`)
+	if err := waitResult(t, result); err != context.DeadlineExceeded {
+		t.Errorf("Call() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// late response is ignored
+	c.send(`30[null,1]`)
+
+	result = call(context.Background(), c.Socket, "slow", nil, nil)
+	c.expect(`21["slow",null]`)
+	c.close()
+	if err := waitResult(t, result); err != engineio.ErrSocketClosed {
+		t.Errorf("Call() of disconnected socket error = %v, want %v", err, engineio.ErrSocketClosed)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	server, c := newTestServerClient(t, ServerOptions{})
+	errs := make(chan error, 1)
+	server.OnError(func(socket *Socket, event string, err error) {
+		errs <- err
+	})
+
+	c.Socket.Handle("echo", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		v := map[string]int{}
+		err := req.Decode(&v)
+		return v, err
+	})
+	c.Socket.Handle("deny", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		return nil, &RPCError{Message: "denied", Code: "E403"}
+	})
+	c.Socket.Handle("fail", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	c.Socket.Handle("panic", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		panic("oops")
+	})
+
+	c.send(`20["echo",{"a":1}]`, `21["deny"]`, `22["fail"]`, `23["missing"]`, `24["panic"]`)
+	c.expect(`30[null,{"a":1}]`)
+	c.expect(`31[{"message":"denied","code":"E403"},null]`)
+	c.expect(`32[{"message":"failed"},null]`)
+	c.expect(`33[{"message":"unknown method missing"},null]`)
+	c.expect(`34[{"message":"internal error"},null]`)
+
+	select {
+	case err := <-errs:
+		if _, isOk := err.(*engineio.PanicError); !isOk {
+			t.Errorf("reported error is %v, want panic", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic of handler is not reported")
+	}
+}
+
+func TestHandleOrder(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	handled := make(chan string, 2)
+	c.Socket.Handle("slow", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		handled <- "slow"
+		return nil, nil
+	})
+	c.Socket.On("event", func(...interface{}) {
+		handled <- "event"
+	})
+
+	// call is handled before event sent after it
+	c.send(`20["slow"]`, `2["event"]`)
+	for _, want := range []string{"slow", "event"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("handled %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q is not handled", want)
+		}
+	}
+	c.expect(`30[null,null]`)
+}
+
+func TestUnknownMethodWithoutHandle(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	c.Socket.On("done", func(...interface{}) {
+		c.Socket.Emit("finished")
+	})
+
+	// socket handling no method does not answer events it does not listen
+	c.send(`20["missing"]`, `2["done"]`)
+	c.expect(`2["finished"]`)
+}
+
+func TestCallInHandler(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	c.Socket.Handle("order", func(ctx context.Context, req *RPCRequest) (interface{}, error) {
+		var confirmed bool
+		err := c.Socket.Call(ctx, "confirm", nil, &confirmed)
+		return confirmed, err
+	})
+	confirmed := make(chan bool, 1)
+	c.Socket.On("pay", func(...interface{}) {
+		var ok bool
+		c.Socket.Call(context.Background(), "confirm", nil, &ok)
+		confirmed <- ok
+	})
+
+	// acknowledgement is handled while handler waits it
+	c.send(`20["order"]`)
+	c.expect(`20["confirm",null]`)
+	c.send(`30[null,true]`)
+	c.expect(`30[null,true]`)
+
+	c.send(`2["pay"]`)
+	c.expect(`21["confirm",null]`)
+	c.send(`31[null,true]`)
+	select {
+	case ok := <-confirmed:
+		if !ok {
+			t.Error("reply of Call in event handler is false")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Call in event handler is not answered")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"sync"
 
+	"github.com/ghuvrons/siosver/emitter"
 	"github.com/ghuvrons/siosver/engineio"
 	"github.com/google/uuid"
 )
@@ -13,18 +14,76 @@ import (
 type ServerOptions struct {
 	PingTimeout  int
 	PingInterval int
+
+	// max bytes of a polling request body or a websocket message
+	MaxHTTPBufferSize int
+
+	// compression of websocket messages and polling responses, nil to disable
+	PerMessageDeflate *engineio.CompressionOptions
+	HTTPCompression   *engineio.CompressionOptions
+
+	// CORS headers and origin checks of handshakes, nil to disable
+	CORS *engineio.CORSOptions
+
+	// cookie set on handshake with session id, nil to disable
+	Cookie *engineio.CookieOptions
+
+	// accept socket.io-client 2.x (Engine.IO v3)
+	AllowEIO3 bool
+
+	// reject JSONP polling requests
+	DisableJSONP bool
+
+	// max number of messages queued to a socket and what to do when it's full
+	OutboxSize   int
+	OutboxPolicy engineio.OutboxPolicy
+
+	// max number of sockets whose event handlers run concurrently
+	DispatchWorkers int
+
+	// max number of handlers queued to a socket, its packets are not read
+	// while it's full
+	DispatchQueueSize int
+
+	// take client address from X-Forwarded-For header set by reverse proxy
+	TrustProxy bool
+
+	// presence events broadcast to rooms, nil to disable
+	Presence *PresenceOptions
+
+	// keeps emits to users without connected socket, nil to disable
+	OfflineStore OfflineStore
 }
 
 type Server struct {
 	engineio   *engineio.Server
-	Sockets    Sockets // [TODO] make it private
+	sockets    Sockets
 	socketsMtx *sync.Mutex
 
 	handlers struct {
 		connection func(*Socket)
+		error      func(socket *Socket, event string, err error)
 	}
 
-	Rooms         map[string]*Room // key: roomName
+	// rooms, sockets of rooms and rooms of sockets are guarded by roomsMtx
+	rooms    map[string]*Room // key: roomName
+	roomsMtx *sync.Mutex
+
+	// room events queued while roomsMtx is locked, emitted in order
+	roomEvents      *emitter.EventEmitter
+	roomEventsQueue []roomEvent
+	isEmittingRoom  bool
+
+	presenceOptions *PresenceOptions
+
+	offlineStore OfflineStore
+	offlineMtx   *sync.Mutex
+
+	// emits to rooms having history are serialized by historyMtx, so joining
+	// sockets get history before live events
+	histories  map[string]*roomHistory // key: roomName
+	historyMtx *sync.Mutex
+
 	authenticator func(interface{}) bool
 }
 
@@ -34,19 +93,57 @@ func NewServer(opt ServerOptions) (server *Server) {
 	eioOptions := engineio.EngineIOOptions{
 		PingInterval: opt.PingInterval,
 		PingTimeout:  opt.PingTimeout,
+
+		MaxHTTPBufferSize: opt.MaxHTTPBufferSize,
+		PerMessageDeflate: opt.PerMessageDeflate,
+		HTTPCompression:   opt.HTTPCompression,
+		CORS:              opt.CORS,
+		Cookie:            opt.Cookie,
+		AllowEIO3:         opt.AllowEIO3,
+		DisableJSONP:      opt.DisableJSONP,
+		OutboxSize:        opt.OutboxSize,
+		OutboxPolicy:      opt.OutboxPolicy,
+		DispatchWorkers:   opt.DispatchWorkers,
+		DispatchQueueSize: opt.DispatchQueueSize,
+		TrustProxy:        opt.TrustProxy,
 	}
 
 	server = &Server{
 		engineio:   engineio.NewServer(eioOptions),
-		Sockets:    Sockets{},
+		sockets:    Sockets{},
 		socketsMtx: &sync.Mutex{},
-		Rooms:      map[string]*Room{},
+		rooms:      map[string]*Room{},
+		roomsMtx:   &sync.Mutex{},
+		roomEvents: emitter.New(),
+
+		presenceOptions: opt.Presence,
+
+		offlineStore: opt.OfflineStore,
+		offlineMtx:   &sync.Mutex{},
+
+		histories:  map[string]*roomHistory{},
+		historyMtx: &sync.Mutex{},
+	}
+
+	if opt.Presence != nil {
+		server.broadcastPresence(opt.Presence)
 	}
 
+	// panics of engine.io goroutines and of handling packets
+	server.engineio.OnError(func(c *engineio.Socket, err error) {
+		server.reportError(nil, "", err)
+	})
+
 	server.engineio.OnConnection(func(c *engineio.Socket) {
-		c.SetCtxValue(managerCtxKey, newManager(server))
-		c.OnMessage(onEngineIOSocketRecvPacket)
+		manager := newManager(server)
+		c.SetCtxValue(managerCtxKey, manager)
+		c.OnReceive(onEngineIOSocketRecvPacket)
 		c.OnClosed(onEngineIOSocketClosed)
+
+		// socket.io-client 2.x connects to default namespace implicitly
+		if c.Protocol() == engineio.PROTOCOL_V3 {
+			manager.connect(c, newPacket(__SIO_PACKET_CONNECT))
+		}
 	})
 
 	return
@@ -64,25 +161,139 @@ func (server *Server) OnConnection(f func(*Socket)) {
 	server.handlers.connection = f
 }
 
+// OnInitialHeaders add handler to modify headers of handshake response
+func (server *Server) OnInitialHeaders(f func(http.Header, *http.Request)) {
+	server.engineio.OnInitialHeaders(f)
+}
+
+// OnHeaders add handler to modify headers of every response
+func (server *Server) OnHeaders(f func(http.Header, *http.Request)) {
+	server.engineio.OnHeaders(f)
+}
+
+// Sockets returns connected sockets
+func (server *Server) Sockets() Sockets {
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+
+	sockets := make(Sockets, len(server.sockets))
+	for id, socket := range server.sockets {
+		sockets[id] = socket
+	}
+	return sockets
+}
+
+// Socket returns connected socket by id, nil if not found
+func (server *Server) Socket(id string) *Socket {
+	sid, err := uuid.Parse(id)
+	if err != nil {
+		return nil
+	}
+
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+	return server.sockets[sid]
+}
+
+// To returns operator emitting to sockets of rooms. Socket id can be used as
+// room name to emit to the socket.
+func (server *Server) To(roomNames ...string) *BroadcastOperator {
+	return &BroadcastOperator{server: server, roomNames: roomNames}
+}
+
+// ToUser returns operator emitting to all sockets of users bound by
+// Socket.SetUser
+func (server *Server) ToUser(ids ...string) *BroadcastOperator {
+	return (&BroadcastOperator{server: server}).ToUser(ids...)
+}
+
+// DisconnectUser disconnects all sockets of users
+func (server *Server) DisconnectUser(ids ...string) {
+	for _, socket := range server.ToUser(ids...).targets() {
+		socket.Disconnect()
+	}
+}
+
+func (server *Server) addSocket(socket *Socket) {
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+	server.sockets[socket.id] = socket
+}
+
+func (server *Server) removeSocket(socket *Socket) {
+	server.socketsMtx.Lock()
+	defer server.socketsMtx.Unlock()
+	delete(server.sockets, socket.id)
+}
+
 // Room methods
-func (server *Server) CreateRoom(roomName string) (room *Room) {
-	room = &Room{
-		Name:    roomName,
-		sockets: map[uuid.UUID]*Socket{},
+
+// Room returns room by name, nil if not found
+func (server *Server) Room(roomName string) *Room {
+	server.roomsMtx.Lock()
+	defer server.roomsMtx.Unlock()
+	return server.rooms[roomName]
+}
+
+// Rooms returns existing rooms
+func (server *Server) Rooms() map[string]*Room {
+	server.roomsMtx.Lock()
+	defer server.roomsMtx.Unlock()
+
+	rooms := make(map[string]*Room, len(server.rooms))
+	for name, room := range server.rooms {
+		rooms[name] = room
 	}
+	return rooms
+}
 
-	if server.Rooms == nil {
-		server.Rooms = map[string]*Room{}
+// CreateRoom returns room by name, created if not found. Room created
+// without sockets is kept until its last socket leaves or it is deleted.
+func (server *Server) CreateRoom(roomName string) *Room {
+	server.roomsMtx.Lock()
+	defer server.unlockRooms()
+	return server.createRoom(roomName)
+}
+
+// createRoom is CreateRoom with server.roomsMtx locked
+func (server *Server) createRoom(roomName string) *Room {
+	if room, isFound := server.rooms[roomName]; isFound {
+		return room
 	}
 
-	server.Rooms[roomName] = room
-	return
+	room := &Room{
+		Name:    roomName,
+		server:  server,
+		sockets: Sockets{},
+		data:    map[string]interface{}{},
+		dataMtx: &sync.Mutex{},
+
+		members:    map[string]*PresenceMember{},
+		memberKeys: map[uuid.UUID]string{},
+	}
+	server.rooms[roomName] = room
+	server.queueRoomEvent(ROOM_EVENT_CREATE, room)
+	return room
 }
 
+// DeleteRoom removes all sockets from room and deletes it
 func (server *Server) DeleteRoom(roomName string) {
-	delete(server.Rooms, roomName)
+	server.roomsMtx.Lock()
+	defer server.unlockRooms()
+
+	room, isFound := server.rooms[roomName]
+	if !isFound {
+		return
+	}
+	for _, socket := range room.sockets {
+		room.leave(socket)
+	}
+	room.delete()
 }
 
+// onEngineIOSocketRecvPacket decodes packets in reading goroutine of
+// engine.io socket. Acknowledgements are resolved at once, so handlers can
+// wait them, other packets are dispatched in order.
 func onEngineIOSocketRecvPacket(eioSocket *engineio.Socket, message interface{}) {
 	manager, isOk := eioSocket.GetCtxValue(managerCtxKey).(*Manager)
 
@@ -90,82 +301,79 @@ func onEngineIOSocketRecvPacket(eioSocket *engineio.Socket, message interface{})
 		return
 	}
 
-	isBinary := false
-
-	var msgBytes []byte
-	var msgString string
+	var packet *packet
 
 	switch data := message.(type) {
 	case string:
-		msgString = data
-	case []byte:
-		isBinary = true
-		msgBytes = data
-	}
+		packet = decodeToPacket(bytes.NewBuffer([]byte(data)))
+		if packet == nil {
+			return
+		}
+		if packet.packetType == __SIO_PACKET_BINARY_EVENT || packet.packetType == __SIO_PACKET_BINARY_ACK {
+			eioSocket.IsReadingPayload = true
+			manager.tmpPacket = packet
+			return
+		}
 
-	if isBinary {
-		if manager.bufferingsocket == nil {
+	case []byte:
+		packet = manager.tmpPacket
+		if packet == nil || packet.numOfBuffer <= 0 {
 			return
 		}
+		sioPacketSetBuffer(packet.data, bytes.NewBuffer(data))
+		packet.numOfBuffer--
 
-		if packet := manager.bufferingsocket.tmpPacket; packet != nil && packet.numOfBuffer > 0 {
-			buf := bytes.NewBuffer(msgBytes)
-			sioPacketSetBuffer(packet.data, buf)
-			packet.numOfBuffer--
-
-			// buffering complete
-			if packet.numOfBuffer == 0 {
-				eioSocket.IsReadingPayload = false
-				manager.bufferingsocket.onMessage(packet)
-				manager.bufferingsocket.tmpPacket = nil
-				manager.bufferingsocket = nil
-			}
+		// buffering complete
+		if packet.numOfBuffer > 0 {
+			return
 		}
-		return
-	}
+		eioSocket.IsReadingPayload = false
+		manager.tmpPacket = nil
 
-	buf := bytes.NewBuffer([]byte(msgString))
-	packet := decodeToPacket(buf)
-	if packet == nil {
+	default:
 		return
 	}
 
-	if packet.packetType == __SIO_PACKET_CONNECT {
-		socket := newSocket(manager.server, packet.namespace)
-		socket.eioSocket = eioSocket
-		manager.sockets[packet.namespace] = socket
-		socket.connect(packet)
+	if packet.packetType == __SIO_PACKET_ACK || packet.packetType == __SIO_PACKET_BINARY_ACK {
+		if socket := manager.socket(packet.namespace); socket != nil {
+			socket.onAck(packet)
+		}
 		return
 	}
 
-	socket, isFound := manager.sockets[packet.namespace]
-	if !isFound || socket == nil {
+	eioSocket.Dispatch(func() {
+		manager.onPacket(eioSocket, packet)
+	})
+}
+
+// onPacket handles packet of client except acknowledgement
+func (manager *Manager) onPacket(eioSocket *engineio.Socket, packet *packet) {
+	if packet.packetType == __SIO_PACKET_CONNECT {
+		// socket.io-client 2.x sends auth as namespace query: 0/admin?token=abc,
+		if eioSocket.Protocol() == engineio.PROTOCOL_V3 {
+			packet.namespace, packet.data = splitNamespaceQuery(packet.namespace)
+		}
+		manager.connect(eioSocket, packet)
 		return
 	}
 
 	switch packet.packetType {
 	case __SIO_PACKET_EVENT, __SIO_PACKET_BINARY_EVENT:
-		if packet.packetType == __SIO_PACKET_BINARY_EVENT {
-			eioSocket.IsReadingPayload = true
-			socket.tmpPacket = packet
-			manager.bufferingsocket = socket
-
-		} else {
+		if socket := manager.socket(packet.namespace); socket != nil {
 			socket.onMessage(packet)
 		}
-		return
 
 	case __SIO_PACKET_DISCONNECT:
-		socket.onClosing()
-		socket.onClose()
+		if socket := manager.remove(packet.namespace); socket != nil {
+			socket.disconnect(false)
+		}
 	}
 }
 
 func onEngineIOSocketClosed(eioSocket *engineio.Socket) {
 	if manager, isOk := eioSocket.GetCtxValue(managerCtxKey).(*Manager); isOk {
-		for _, socket := range manager.sockets {
-			socket.onClosing()
-			socket.onClose()
+		for _, socket := range manager.removeAll() {
+			socket.disconnect(false)
 		}
 	}
 }
@@ -0,0 +1,188 @@
+package siosver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient is Socket.IO v5 client over polling transport
+type testClient struct {
+	t       *testing.T
+	url     string
+	http    *http.Client
+	packets []string    // received but not read by next
+	polling chan string // body of GET sent by pollInBackground
+
+	// Socket is server side socket of client
+	Socket *Socket
+}
+
+// newTestClient connects client to server served by ts
+func newTestClient(t *testing.T, server *Server, ts *httptest.Server) *testClient {
+	t.Helper()
+
+	c := &testClient{
+		t:    t,
+		url:  ts.URL + "/socket.io/?EIO=4&transport=polling",
+		http: &http.Client{Timeout: 2 * time.Second},
+	}
+
+	open := c.next()
+	handshake := struct{ Sid string }{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(open, "0")), &handshake); err != nil {
+		t.Fatalf("bad OPEN packet %q", open)
+	}
+	c.url += "&sid=" + handshake.Sid
+
+	c.send("0")
+	connect := struct{ Sid string }{}
+	if p := c.next(); !strings.HasPrefix(p, "40") || json.Unmarshal([]byte(p[2:]), &connect) != nil {
+		t.Fatalf("bad CONNECT packet %q", p)
+	}
+
+	c.Socket = server.Socket(connect.Sid)
+	if c.Socket == nil {
+		t.Fatalf("socket %s is not found", connect.Sid)
+	}
+	return c
+}
+
+// send sends Socket.IO packets, e.g. `2["event"]`
+func (c *testClient) send(packets ...string) {
+	c.t.Helper()
+
+	payload := []string{}
+	for _, p := range packets {
+		payload = append(payload, "4"+p)
+	}
+	c.post(strings.Join(payload, "\x1e"))
+}
+
+// close closes Engine.IO session and waits server side socket closed
+func (c *testClient) close() {
+	c.t.Helper()
+
+	c.post("1")
+	select {
+	case <-c.Socket.Context().Done():
+	case <-time.After(time.Second):
+		c.t.Fatal("socket is not closed")
+	}
+}
+
+func (c *testClient) post(payload string) {
+	c.t.Helper()
+
+	resp, err := c.http.Post(c.url, "text/plain;charset=UTF-8", strings.NewReader(payload))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// next returns next received Engine.IO packet, polling if needed
+func (c *testClient) next() string {
+	c.t.Helper()
+
+	for len(c.packets) == 0 {
+		var body string
+		if c.polling != nil {
+			select {
+			case body = <-c.polling:
+			case <-time.After(2 * time.Second):
+				c.t.Fatal("GET is not answered")
+			}
+			c.polling = nil
+		} else {
+			body = c.get()
+		}
+
+		for _, p := range strings.Split(body, "\x1e") {
+			// skip heartbeat
+			if p != "" && p != "2" && p != "6" {
+				c.packets = append(c.packets, p)
+			}
+		}
+	}
+
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return p
+}
+
+func (c *testClient) get() string {
+	c.t.Helper()
+
+	resp, err := c.http.Get(c.url)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return string(b)
+}
+
+// pollInBackground sends GET whose response is read by next, and waits
+// until server transport is writable
+func (c *testClient) pollInBackground() {
+	c.t.Helper()
+
+	c.polling = make(chan string, 1)
+	go func(polling chan string) {
+		resp, err := c.http.Get(c.url)
+		if err != nil {
+			polling <- ""
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		polling <- string(b)
+	}(c.polling)
+
+	for i := 0; !c.Socket.eioSocket.Transport().Writable(); i++ {
+		if i == 100 {
+			c.t.Fatal("GET is not waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expect reads next packet which must be Socket.IO packet want
+func (c *testClient) expect(want string) {
+	c.t.Helper()
+
+	if got := c.next(); got != "4"+want {
+		c.t.Errorf("received %q, want %q", got, "4"+want)
+	}
+}
+
+func TestSocketsOperator(t *testing.T) {
+	server := NewServer(ServerOptions{
+		PingInterval: 25000,
+		PingTimeout:  20000,
+		OfflineStore: NewMemoryOfflineStore(0, 0),
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	a := newTestClient(t, server, ts)
+	b := newTestClient(t, server, ts)
+	b.Socket.SocketJoin("room")
+
+	sockets := Sockets{a.Socket.id: a.Socket}
+	sockets.Compress(false).To("room").ToUser("offline").Emit("event")
+	a.expect(`2["event"]`)
+	b.expect(`2["event"]`)
+
+	// operator of no socket has no room
+	Sockets{}.Compress(false).To("room").ToUser("offline").Emit("event")
+}
@@ -1,6 +1,10 @@
 package siosver
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/ghuvrons/siosver/emitter"
 	"github.com/ghuvrons/siosver/engineio"
 	"github.com/google/uuid"
@@ -12,30 +16,68 @@ type Socket struct {
 	eioSocket    *engineio.Socket
 	namespace    string
 	eventEmitter *emitter.EventEmitter
-	tmpPacket    *packet
+	handshake    Handshake
 
-	// when Socket get events with ack. that ACK will be saved to this
-	ackIdHandling int
+	// acknowledgements waited from client and rpc handlers
+	acks        map[int]func([]interface{}) // key: ackId
+	nextAckId   int
+	rpcHandlers map[string]RPCHandler // key: method
+	acksMtx     *sync.Mutex
 
 	handlers struct {
 		disconnecting func(reason int)
 		disconnect    func(reason int)
 	}
 
-	// rooms that connected by this socket
-	rooms map[string]*Room // key: roomName
+	// rooms that connected by this socket, guarded by server.roomsMtx
+	rooms    map[string]*Room // key: roomName
+	isClosed bool             // guarded by server.roomsMtx
+	user     string           // guarded by server.roomsMtx
+
+	closeOnce *sync.Once
+
+	// locked while messages of offline store or room history are queued, so
+	// they are sent before messages emitted after SetUser or JoinWithHistory
+	deliverMtx *sync.RWMutex
+
+	// data set by Set, e.g. authenticated user
+	data    map[string]interface{}
+	dataMtx *sync.Mutex
+
+	ctx           context.Context
+	ctxCancelFunc context.CancelFunc
 }
 
 type Sockets map[uuid.UUID]*Socket
 
-// newSocket create new Socket
-func newSocket(server *Server, namespace string) *Socket {
+// Handshake is details of HTTP request that created the connection and auth
+// data sent by client on connecting to namespace
+type Handshake struct {
+	engineio.Handshake
+	Auth interface{}
+}
+
+// newSocket create new Socket whose context is cancelled when it disconnects
+// or parent is done
+func newSocket(server *Server, namespace string, parent context.Context) *Socket {
+	ctx, cancelFunc := context.WithCancel(parent)
+
 	return &Socket{
 		server:       server,
 		id:           uuid.New(),
 		namespace:    namespace,
 		eventEmitter: emitter.New(),
 		rooms:        map[string]*Room{},
+		closeOnce:    &sync.Once{},
+		deliverMtx:   &sync.RWMutex{},
+		acks:         map[int]func([]interface{}){},
+		rpcHandlers:  map[string]RPCHandler{},
+		acksMtx:      &sync.Mutex{},
+		data:         map[string]interface{}{},
+		dataMtx:      &sync.Mutex{},
+
+		ctx:           ctx,
+		ctxCancelFunc: cancelFunc,
 	}
 }
 
@@ -48,8 +90,23 @@ func (socket *Socket) connect(conpacket *packet) {
 		data = conpacket.data
 	}
 
+	socket.handshake = Handshake{
+		Handshake: socket.eioSocket.Handshake(),
+		Auth:      data,
+	}
+
+	// socket.io-client 2.x
+	isV2 := socket.eioSocket.Protocol() == engineio.PROTOCOL_V3
+
 	if socket.server.authenticator != nil {
 		if !socket.server.authenticator(data) {
+			if isV2 {
+				p := newPacket(__SIO_PACKET_CONNECT_ERROR)
+				p.data = "Not authorized"
+				socket.send(p)
+				return
+			}
+
 			errConnData := map[string]interface{}{
 				"message": "Not authorized",
 				"data": map[string]interface{}{
@@ -63,60 +120,163 @@ func (socket *Socket) connect(conpacket *packet) {
 	}
 
 	// if success
-	socket.send(newPacket(__SIO_PACKET_CONNECT, map[string]interface{}{"sid": socket.id.String()}))
+	if isV2 {
+		socket.send(newPacket(__SIO_PACKET_CONNECT))
+	} else {
+		socket.send(newPacket(__SIO_PACKET_CONNECT, map[string]interface{}{"sid": socket.id.String()}))
+	}
+
+	socket.server.addSocket(socket)
+
+	// every socket is in room named after its id
+	socket.SocketJoin(socket.id.String())
+
+	if f := socket.server.handlers.connection; f != nil {
+		go socket.runHandler("connection", func() { f(socket) })
+	}
+}
 
-	socket.server.socketsMtx.Lock()
-	socket.server.Sockets[socket.id] = socket
-	socket.server.socketsMtx.Unlock()
+// ID returns id of socket, which is also name of its private room
+func (socket *Socket) ID() string {
+	return socket.id.String()
+}
+
+// Rooms returns names of rooms joined by socket
+func (socket *Socket) Rooms() []string {
+	socket.server.roomsMtx.Lock()
+	defer socket.server.roomsMtx.Unlock()
 
-	if socket.server.handlers.connection != nil {
-		go socket.server.handlers.connection(socket)
+	names := make([]string, 0, len(socket.rooms))
+	for name := range socket.rooms {
+		names = append(names, name)
 	}
+	return names
+}
+
+// Handshake returns details of connection of socket
+func (socket *Socket) Handshake() Handshake {
+	return socket.handshake
+}
+
+// Context returns context of socket which is cancelled when socket
+// disconnects. It carries values of handshake request context.
+func (socket *Socket) Context() context.Context {
+	return socket.ctx
+}
+
+// Set stores value of key in socket, e.g. authenticated user
+func (socket *Socket) Set(key string, value interface{}) {
+	socket.dataMtx.Lock()
+	defer socket.dataMtx.Unlock()
+	socket.data[key] = value
+}
+
+// Get returns value of key stored by Set, nil if not found
+func (socket *Socket) Get(key string) interface{} {
+	socket.dataMtx.Lock()
+	defer socket.dataMtx.Unlock()
+	return socket.data[key]
+}
+
+// Data returns copy of values stored by Set
+func (socket *Socket) Data() map[string]interface{} {
+	socket.dataMtx.Lock()
+	defer socket.dataMtx.Unlock()
+
+	data := make(map[string]interface{}, len(socket.data))
+	for key, value := range socket.data {
+		data[key] = value
+	}
+	return data
 }
 
 func (socket *Socket) send(p *packet) {
+	socket.sendWithFlags(p, emitFlags{})
+}
+
+func (socket *Socket) sendWithFlags(p *packet, flags emitFlags) error {
+	// wait messages of offline store or history being queued, see SetUser
+	// and JoinWithHistory
+	socket.deliverMtx.RLock()
+	socket.deliverMtx.RUnlock()
+
+	return socket.sendPacket(p, flags)
+}
+
+func (socket *Socket) sendPacket(p *packet, flags emitFlags) error {
 	p.namespace = socket.namespace
 	encodedPacket, buffers := p.encode()
 
-	if len(buffers) == 0 {
-		socket.eioSocket.Send(encodedPacket)
-
-	} else {
-		// binary message
-		if err := socket.eioSocket.Send(encodedPacket); err != nil {
-			return
-		}
+	// attachments must follow their packet, so they are sent together
+	messages := []interface{}{encodedPacket}
+	for _, buf := range buffers {
+		messages = append(messages, buf.Bytes())
+	}
 
-		for _, buf := range buffers {
-			if err := socket.eioSocket.Send(buf.Bytes()); err != nil {
-				return
-			}
-		}
+	eioOptions := engineio.SendOptions{
+		NoCompress:  flags.noCompress,
+		WaitWritten: flags.waitWritten,
+		Written:     flags.written,
+		Volatile:    flags.volatile,
 	}
+	return socket.eioSocket.SendAllWithOptions(messages, eioOptions, flags.timeout)
 }
 
 func (socket *Socket) Emit(arg ...interface{}) {
 	socket.send(newPacket(__SIO_PACKET_EVENT, arg...))
 }
 
-func (socket *Socket) On(event string, f func(...interface{})) {
-	socket.eventEmitter.On(event, f)
+// TryEmit emits event and returns error if it can not be queued, e.g.
+// engineio.ErrSocketClosed
+func (socket *Socket) TryEmit(arg ...interface{}) error {
+	return socket.sendWithFlags(newPacket(__SIO_PACKET_EVENT, arg...), emitFlags{})
+}
+
+// EmitAndWait emits event and blocks until it is written to transport. It
+// fails with engineio.ErrSocketClosed or engineio.ErrTimeout. Zero timeout
+// waits forever.
+func (socket *Socket) EmitAndWait(timeout time.Duration, arg ...interface{}) error {
+	return (&SocketEmitter{socket: socket}).EmitAndWait(timeout, arg...)
+}
+
+// Volatile makes emitted data dropped if client is not ready to receive it
+func (socket *Socket) Volatile() *SocketEmitter {
+	return (&SocketEmitter{socket: socket}).Volatile()
+}
+
+// Compress sets whether next emit data will be compressed
+func (socket *Socket) Compress(compress bool) *SocketEmitter {
+	return (&SocketEmitter{socket: socket}).Compress(compress)
+}
+
+// On adds handler of event and returns func removing it. Last argument is
+// ack callback if client asks for acknowledgement.
+func (socket *Socket) On(event string, f func(...interface{})) func() {
+	return socket.eventEmitter.On(event, f)
 }
 
 func (socket *Socket) onMessage(p *packet) {
 	args, isOk := p.data.([]interface{})
-	defer func() {
-		socket.ackIdHandling = 0
-	}()
 
 	if isOk && len(args) > 0 {
 		switch args[0].(type) {
 		case string:
 			event := args[0].(string)
+			defer socket.server.recoverPanic(socket, event)
+
+			// calls run in order with events, by dispatcher of engine.io
+			f, isHandling := socket.rpcHandler(event)
+			if f != nil {
+				socket.handleCall(f, event, args[1:], p.ackId)
+				return
+			}
+			if p.ackId >= 0 && isHandling && socket.eventEmitter.ListenerCount(event) == 0 {
+				socket.send(newPacket(__SIO_PACKET_ACK, &RPCError{Message: "unknown method " + event}, nil).withAck(p.ackId))
+				return
+			}
 
 			if p.ackId >= 0 {
-				socket.ackIdHandling = p.ackId
-				socket.eventEmitter.Emit(event, append(args[1:], socket.callbackAck)...)
+				socket.eventEmitter.Emit(event, append(args[1:], socket.ackCallback(p.ackId))...)
 			} else {
 				socket.eventEmitter.Emit(event, args[1:]...)
 			}
@@ -124,14 +284,27 @@ func (socket *Socket) onMessage(p *packet) {
 	}
 }
 
-func (socket *Socket) callbackAck(arg ...interface{}) {
-	socket.send(newPacket(__SIO_PACKET_ACK, arg...).withAck(socket.ackIdHandling))
+// ackCallback returns callback acknowledging event of ackId
+func (socket *Socket) ackCallback(ackId int) func(...interface{}) {
+	return func(arg ...interface{}) {
+		socket.send(newPacket(__SIO_PACKET_ACK, arg...).withAck(ackId))
+	}
 }
 
 func (socket *Socket) Disconnect() {
-	socket.onClosing()
-	socket.send(newPacket(__SIO_PACKET_DISCONNECT))
-	socket.onClose()
+	socket.disconnect(true)
+}
+
+// disconnect runs disconnecting handler, sends DISCONNECT packet if
+// notifyClient, cleans up socket and runs disconnect handler. It runs once.
+func (socket *Socket) disconnect(notifyClient bool) {
+	socket.closeOnce.Do(func() {
+		socket.onClosing()
+		if notifyClient {
+			socket.send(newPacket(__SIO_PACKET_DISCONNECT))
+		}
+		socket.onClose()
+	})
 }
 
 func (socket *Socket) OnDisconnecting(f func(reason int)) {
@@ -143,91 +316,140 @@ func (socket *Socket) OnDisconnect(f func(reason int)) {
 }
 
 func (socket *Socket) onClosing() {
-	if socket.handlers.disconnecting != nil {
-		socket.handlers.disconnecting(0)
+	if f := socket.handlers.disconnecting; f != nil {
+		socket.runHandler("disconnecting", func() { f(0) })
 	}
 }
 
 func (socket *Socket) onClose() {
-	socket.server.socketsMtx.Lock()
-	delete(socket.server.Sockets, socket.id)
-	socket.server.socketsMtx.Unlock()
+	socket.ctxCancelFunc()
+	socket.server.removeSocket(socket)
 
+	socket.server.roomsMtx.Lock()
+	socket.isClosed = true
 	for _, room := range socket.rooms {
 		room.leave(socket)
 	}
+	socket.server.unlockRooms()
 
-	if socket.handlers.disconnect != nil {
-		socket.handlers.disconnect(0)
+	if f := socket.handlers.disconnect; f != nil {
+		socket.runHandler("disconnect", func() { f(0) })
 	}
 }
 
-func (socket *Socket) SocketJoin(roomName string) {
-	room, isFound := socket.server.Rooms[roomName]
-	if !isFound {
-		room = socket.server.CreateRoom(roomName)
+// SetUser binds socket to user id, e.g. by authentication middleware, so it
+// receives emits of Server.ToUser. Empty id unbinds socket.
+//
+// Messages kept by ServerOptions.OfflineStore for the user are delivered to
+// the socket before messages emitted after binding. User of a socket is not
+// known until it is bound, so delivery happens here and not when socket
+// completes namespace CONNECT.
+func (socket *Socket) SetUser(id string) {
+	if socket.server.offlineStore == nil {
+		socket.setUser(id)
+		return
 	}
-	room.join(socket)
-}
 
-func (socket *Socket) SocketLeave(roomName string) {
-	room, isFound := socket.server.Rooms[roomName]
-	if !isFound {
-		return
+	// sockets of user get emits of ToUser after they are bound, messages
+	// emitted before are stored
+	socket.server.offlineMtx.Lock()
+	var messages []OfflineMessage
+	if socket.setUser(id) && id != "" {
+		messages = socket.popOffline(id)
+	}
+	socket.server.offlineMtx.Unlock()
+
+	if len(messages) > 0 {
+		go socket.deliverOffline(id, messages)
 	}
-	room.leave(socket)
 }
 
-func (sockets Sockets) Emit(arg ...interface{}) {
-	packet := newPacket(__SIO_PACKET_EVENT, arg...)
-	for _, socket := range sockets {
-		socket.send(packet)
+// setUser binds socket to user id, returns false if not changed
+func (socket *Socket) setUser(id string) bool {
+	socket.server.roomsMtx.Lock()
+	defer socket.server.unlockRooms()
+
+	if socket.isClosed || socket.user == id {
+		return false
+	}
+	if room, isFound := socket.rooms[userRoomName(socket.user)]; isFound {
+		room.leave(socket)
+	}
+	socket.user = id
+	if id != "" {
+		socket.server.createRoom(userRoomName(id)).join(socket)
+	}
+
+	// sockets of user count once in rooms joined before
+	for _, room := range socket.rooms {
+		room.rekeyMember(socket)
 	}
+	return true
 }
 
-func (sockets Sockets) SocketJoin(roomName string) {
-	var server *Server = nil
+// User returns user id bound by SetUser
+func (socket *Socket) User() string {
+	socket.server.roomsMtx.Lock()
+	defer socket.server.roomsMtx.Unlock()
+	return socket.user
+}
+
+func (socket *Socket) SocketJoin(roomName string) {
+	socket.server.roomsMtx.Lock()
+	defer socket.server.unlockRooms()
 
-	if len(sockets) == 0 {
+	if socket.isClosed {
 		return
 	}
+	socket.server.createRoom(roomName).join(socket)
+}
 
-	for _, socket := range sockets {
-		server = socket.server
-		break
-	}
+func (socket *Socket) SocketLeave(roomName string) {
+	socket.server.roomsMtx.Lock()
+	defer socket.server.unlockRooms()
 
-	room, isFound := server.Rooms[roomName]
-	if !isFound {
-		room = server.CreateRoom(roomName)
+	if room, isFound := socket.rooms[roomName]; isFound {
+		room.leave(socket)
 	}
+}
 
+func (sockets Sockets) Emit(arg ...interface{}) {
+	packet := newPacket(__SIO_PACKET_EVENT, arg...)
 	for _, socket := range sockets {
-		room.join(socket)
+		socket.send(packet)
 	}
 }
 
-func (sockets Sockets) SocketLeave(roomName string) {
-	var server *Server = nil
+// Volatile makes emitted data dropped by sockets not ready to receive it
+func (sockets Sockets) Volatile() *BroadcastOperator {
+	return sockets.operator().Volatile()
+}
 
-	if len(sockets) == 0 {
-		return
-	}
+// Compress sets whether emitted data to the sockets will be compressed
+func (sockets Sockets) Compress(compress bool) *BroadcastOperator {
+	return sockets.operator().Compress(compress)
+}
 
+// operator returns BroadcastOperator emitting to sockets. Its server is
+// server of the sockets, nil if there is no socket, so rooms and users added
+// by To and ToUser are looked up in it.
+func (sockets Sockets) operator() *BroadcastOperator {
+	b := &BroadcastOperator{sockets: sockets}
 	for _, socket := range sockets {
-		server = socket.server
+		b.server = socket.server
 		break
 	}
-	room, isFound := server.Rooms[roomName]
-	if !isFound {
-		return
-	}
+	return b
+}
 
+func (sockets Sockets) SocketJoin(roomName string) {
 	for _, socket := range sockets {
-		room.leave(socket)
+		socket.SocketJoin(roomName)
 	}
+}
 
-	if len(room.sockets) == 0 {
-		server.DeleteRoom(roomName)
+func (sockets Sockets) SocketLeave(roomName string) {
+	for _, socket := range sockets {
+		socket.SocketLeave(roomName)
 	}
 }
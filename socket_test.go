@@ -0,0 +1,141 @@
+package siosver
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
+func newTestServerClient(t *testing.T, opt ServerOptions) (*Server, *testClient) {
+	t.Helper()
+
+	opt.PingInterval = 25000
+	opt.PingTimeout = 20000
+	server := NewServer(opt)
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, newTestClient(t, server, ts)
+}
+
+func TestTryEmit(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	if err := c.Socket.TryEmit("event", 1); err != nil {
+		t.Errorf("TryEmit() error = %v", err)
+	}
+	c.expect(`2["event",1]`)
+
+	c.close()
+	if err := c.Socket.TryEmit("event", 2); err != engineio.ErrSocketClosed {
+		t.Errorf("TryEmit() of closed socket error = %v, want %v", err, engineio.ErrSocketClosed)
+	}
+}
+
+func TestEmitAndWait(t *testing.T) {
+	_, c := newTestServerClient(t, ServerOptions{})
+
+	// written when client polls
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Socket.EmitAndWait(0, "event", 1)
+	}()
+	c.expect(`2["event",1]`)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("EmitAndWait() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitAndWait() is not released by poll")
+	}
+
+	// client does not poll
+	if err := c.Socket.EmitAndWait(50*time.Millisecond, "event", 2); err != engineio.ErrTimeout {
+		t.Errorf("EmitAndWait() error = %v, want %v", err, engineio.ErrTimeout)
+	}
+
+	// client disconnects while waiting
+	go func() {
+		result <- c.Socket.EmitAndWait(0, "event", 3)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.close()
+	select {
+	case err := <-result:
+		if err != engineio.ErrSocketClosed {
+			t.Errorf("EmitAndWait() error = %v, want %v", err, engineio.ErrSocketClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitAndWait() is not released by disconnect")
+	}
+}
+
+func TestVolatile(t *testing.T) {
+	server, a := newTestServerClient(t, ServerOptions{OutboxSize: 1})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	b := newTestClient(t, server, ts)
+	b.Socket.SocketJoin("room")
+
+	// dropped as client is not polling
+	if err := a.Socket.Volatile().TryEmit("event", 1); err != engineio.ErrPacketDropped {
+		t.Errorf("TryEmit() error = %v, want %v", err, engineio.ErrPacketDropped)
+	}
+
+	a.pollInBackground()
+	b.pollInBackground()
+	Sockets{a.Socket.id: a.Socket}.Volatile().To("room").Emit("event", 2)
+	a.expect(`2["event",2]`)
+	b.expect(`2["event",2]`)
+
+	// packet having attachment does not fit and is dropped as a whole
+	a.pollInBackground()
+	a.Socket.Volatile().Emit("event", 3)
+	if err := a.Socket.Volatile().TryEmit("event", []byte{1}); err != engineio.ErrPacketDropped {
+		t.Errorf("TryEmit() error = %v, want %v", err, engineio.ErrPacketDropped)
+	}
+	a.expect(`2["event",3]`)
+}
+
+func TestSocketData(t *testing.T) {
+	socket := newTestSocket(NewServer(ServerOptions{}))
+
+	if v := socket.Get("user"); v != nil {
+		t.Errorf("Get() of unset key = %v, want nil", v)
+	}
+	socket.Set("user", "alice")
+	socket.Set("role", "admin")
+	if v := socket.Get("user"); v != "alice" {
+		t.Errorf("Get() = %v, want alice", v)
+	}
+
+	// Data is a copy
+	data := socket.Data()
+	data["user"] = "mallory"
+	if len(data) != 2 || socket.Get("user") != "alice" {
+		t.Errorf("Data() = %v, Get() after modifying it = %v", data, socket.Get("user"))
+	}
+}
+
+func TestSocketContext(t *testing.T) {
+	// socket not created by connecting client
+	socket := newTestSocket(NewServer(ServerOptions{}))
+	socket.disconnect(false)
+	if socket.Context().Err() != context.Canceled {
+		t.Errorf("Context().Err() after disconnect = %v, want %v", socket.Context().Err(), context.Canceled)
+	}
+
+	_, c := newTestServerClient(t, ServerOptions{})
+	if err := c.Socket.Context().Err(); err != nil {
+		t.Errorf("Context().Err() of connected socket = %v", err)
+	}
+	c.send("1")
+	select {
+	case <-c.Socket.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Context() is not cancelled when client disconnects")
+	}
+}
@@ -1,14 +1,81 @@
 package siosver
 
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ghuvrons/siosver/engineio"
+)
+
 type Manager struct {
-	server          *Server
-	sockets         map[string]*Socket // key: namespace
-	bufferingsocket *Socket
+	server     *Server
+	sockets    map[string]*Socket // key: namespace
+	socketsMtx *sync.Mutex
+	tmpPacket  *packet // binary packet waiting its attachments
 }
 
 func newManager(server *Server) *Manager {
 	return &Manager{
-		server:  server,
-		sockets: map[string]*Socket{}, // key: namespaces
+		server:     server,
+		sockets:    map[string]*Socket{}, // key: namespaces
+		socketsMtx: &sync.Mutex{},
+	}
+}
+
+// connect create socket of namespace requested by CONNECT packet
+func (manager *Manager) connect(eioSocket *engineio.Socket, p *packet) {
+	socket := newSocket(manager.server, p.namespace, eioSocket.Context())
+	socket.eioSocket = eioSocket
+	manager.socketsMtx.Lock()
+	manager.sockets[p.namespace] = socket
+	manager.socketsMtx.Unlock()
+	socket.connect(p)
+}
+
+// socket returns socket of namespace, nil if it is not connected
+func (manager *Manager) socket(namespace string) *Socket {
+	manager.socketsMtx.Lock()
+	defer manager.socketsMtx.Unlock()
+	return manager.sockets[namespace]
+}
+
+// remove removes socket of namespace and returns it
+func (manager *Manager) remove(namespace string) *Socket {
+	manager.socketsMtx.Lock()
+	defer manager.socketsMtx.Unlock()
+	socket := manager.sockets[namespace]
+	delete(manager.sockets, namespace)
+	return socket
+}
+
+// removeAll removes all sockets and returns them
+func (manager *Manager) removeAll() []*Socket {
+	manager.socketsMtx.Lock()
+	defer manager.socketsMtx.Unlock()
+	sockets := make([]*Socket, 0, len(manager.sockets))
+	for namespace, socket := range manager.sockets {
+		sockets = append(sockets, socket)
+		delete(manager.sockets, namespace)
+	}
+	return sockets
+}
+
+// splitNamespaceQuery splits "admin?token=abc" to namespace and query as map
+func splitNamespaceQuery(nsp string) (namespace string, query interface{}) {
+	i := strings.IndexByte(nsp, '?')
+	if i < 0 {
+		return nsp, nil
+	}
+
+	values, err := url.ParseQuery(nsp[i+1:])
+	if err != nil {
+		return nsp[:i], nil
+	}
+
+	data := map[string]interface{}{}
+	for key := range values {
+		data[key] = values.Get(key)
 	}
+	return nsp[:i], data
 }
@@ -0,0 +1,171 @@
+package siosver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClientV2 is socket.io-client 2.x over polling transport of Engine.IO v3
+type testClientV2 struct {
+	t       *testing.T
+	url     string
+	http    *http.Client
+	packets []string // received but not read by next
+}
+
+// newTestClientV2 opens Engine.IO session and returns data of OPEN packet
+func newTestClientV2(t *testing.T, ts *httptest.Server) (*testClientV2, map[string]interface{}) {
+	t.Helper()
+
+	c := &testClientV2{
+		t:    t,
+		url:  ts.URL + "/socket.io/?EIO=3&transport=polling",
+		http: &http.Client{Timeout: 2 * time.Second},
+	}
+
+	open := c.next()
+	data := map[string]interface{}{}
+	if !strings.HasPrefix(open, "0") || json.Unmarshal([]byte(open[1:]), &data) != nil {
+		t.Fatalf("bad OPEN packet %q", open)
+	}
+	sid, _ := data["sid"].(string)
+	c.url += "&sid=" + sid
+	return c, data
+}
+
+// send sends Socket.IO packets as payload <length>:4<packet>...
+func (c *testClientV2) send(packets ...string) {
+	c.t.Helper()
+
+	payload := ""
+	for _, p := range packets {
+		payload += strconv.Itoa(len(p)+1) + ":4" + p
+	}
+	resp, err := c.http.Post(c.url, "text/plain;charset=UTF-8", strings.NewReader(payload))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// next returns next received Engine.IO packet, polling if needed. Payload
+// must be ASCII.
+func (c *testClientV2) next() string {
+	c.t.Helper()
+
+	for len(c.packets) == 0 {
+		resp, err := c.http.Get(c.url)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+
+		for payload := string(b); payload != ""; {
+			sep := strings.IndexByte(payload, ':')
+			if sep < 0 {
+				c.t.Fatalf("bad payload %q", b)
+			}
+			length, err := strconv.Atoi(payload[:sep])
+			if err != nil || len(payload) < sep+1+length {
+				c.t.Fatalf("bad payload %q", b)
+			}
+			c.packets = append(c.packets, payload[sep+1:sep+1+length])
+			payload = payload[sep+1+length:]
+		}
+	}
+
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return p
+}
+
+// expect reads next packet which must be Socket.IO packet want
+func (c *testClientV2) expect(want string) {
+	c.t.Helper()
+
+	if p := c.next(); p != "4"+want {
+		c.t.Fatalf("received %q, want %q", p, "4"+want)
+	}
+}
+
+func TestConnectV2(t *testing.T) {
+	server := NewServer(ServerOptions{AllowEIO3: true, PingInterval: 25000, PingTimeout: 20000})
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	auths := make(chan interface{}, 3)
+	server.Authenticator(func(data interface{}) bool {
+		auths <- data
+		query, _ := data.(map[string]interface{})
+		return data == nil || query["token"] == "abc"
+	})
+
+	c, open := newTestClientV2(t, ts)
+	if _, isFound := open["maxPayload"]; isFound {
+		t.Errorf("OPEN packet of protocol v3 has maxPayload %v", open["maxPayload"])
+	}
+
+	// default namespace is connected implicitly, CONNECT has no data
+	c.expect("0")
+
+	// auth is sent as query of namespace
+	c.send("0/admin?token=abc,")
+	c.expect("0/admin,")
+	c.send("0/admin?token=xyz,")
+	c.expect(`4/admin,"Not authorized"`)
+
+	for _, want := range []interface{}{nil, map[string]interface{}{"token": "abc"}, map[string]interface{}{"token": "xyz"}} {
+		select {
+		case auth := <-auths:
+			if !reflect.DeepEqual(auth, want) {
+				t.Errorf("auth is %v, want %v", auth, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("authenticator is not called")
+		}
+	}
+}
+
+func TestConnectV2NotAuthorized(t *testing.T) {
+	server := NewServer(ServerOptions{AllowEIO3: true, PingInterval: 25000, PingTimeout: 20000})
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	server.Authenticator(func(data interface{}) bool {
+		return false
+	})
+
+	c, _ := newTestClientV2(t, ts)
+	c.expect(`4"Not authorized"`)
+}
+
+func Test_splitNamespaceQuery(t *testing.T) {
+	tests := []struct {
+		nsp           string
+		wantNamespace string
+		wantQuery     interface{}
+	}{
+		{"admin", "admin", nil},
+		{"admin?token=abc&lang=en", "admin", map[string]interface{}{"token": "abc", "lang": "en"}},
+		{"admin?%zz", "admin", nil},
+	}
+
+	for _, tt := range tests {
+		namespace, query := splitNamespaceQuery(tt.nsp)
+		if namespace != tt.wantNamespace || !reflect.DeepEqual(query, tt.wantQuery) {
+			t.Errorf("splitNamespaceQuery(%q) = %q, %v, want %q, %v", tt.nsp, namespace, query, tt.wantNamespace, tt.wantQuery)
+		}
+	}
+}